@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"kjarmicki.github.com/cube/manifest"
+)
+
+const applyUsage = "apply [-manager host:port] [-prune] [-dry-run] -f <manifest file, - for stdin>"
+
+func applyCommand(args []string) int {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	manager := fs.String("manager", defaultManager, "manager API address")
+	file := fs.String("f", "", "manifest file in JSON or YAML")
+	prune := fs.Bool("prune", false, "remove applied resources missing from the manifest")
+	dryRun := fs.Bool("dry-run", false, "only print the plan")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" || fs.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "usage: cube %s\n", applyUsage)
+		return 2
+	}
+	var data []byte
+	var err error
+	if *file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading manifest: %v\n", err)
+		return 1
+	}
+	// catch mistakes before talking to the manager
+	if _, err := manifest.Parse(data); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid manifest: %v\n", err)
+		return 1
+	}
+
+	contentType := "application/json"
+	if strings.HasSuffix(*file, ".yaml") || strings.HasSuffix(*file, ".yml") {
+		contentType = "application/yaml"
+	}
+	url := fmt.Sprintf("http://%s/apply?prune=%t&dryRun=%t", *manager, *prune, *dryRun)
+	resp, err := http.Post(url, contentType, bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error connecting to manager: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	plan := manifest.Plan{}
+	if resp.StatusCode != http.StatusOK {
+		// a plan that partly failed still tells what was done
+		if json.Unmarshal(body, &plan) == nil && plan.Err() != nil {
+			fmt.Print(plan)
+			fmt.Fprintf(os.Stderr, "apply failed: %v\n", plan.Err())
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "apply failed (%d): %s\n", resp.StatusCode, bytes.TrimSpace(body))
+		return 1
+	}
+	if err := json.Unmarshal(body, &plan); err != nil {
+		fmt.Fprintf(os.Stderr, "unexpected response from manager: %v\n", err)
+		return 1
+	}
+	fmt.Print(plan)
+	if !plan.Applied {
+		fmt.Println("dry run, nothing was changed")
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+	"golang.org/x/term"
+	"kjarmicki.github.com/cube/task"
+)
+
+const execUsage = "exec [-manager host:port] [-tty] [-env KEY=VALUE]... <task ID> <command> [args...]"
+
+func execCommand(args []string) int {
+	fs := flag.NewFlagSet("exec", flag.ContinueOnError)
+	manager := fs.String("manager", defaultManager, "manager API address")
+	tty := fs.Bool("tty", false, "allocate a pseudo-TTY in the container")
+	var env stringList
+	fs.Var(&env, "env", "environment variable for the command, can be repeated")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 2 {
+		fmt.Fprintf(os.Stderr, "usage: cube %s\n", execUsage)
+		return 2
+	}
+	taskID, err := uuid.Parse(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid task ID %q\n", fs.Arg(0))
+		return 2
+	}
+
+	body, _ := json.Marshal(task.ExecRequest{Cmd: fs.Args()[1:], Env: env, Tty: *tty})
+	url := fmt.Sprintf("http://%s/tasks/%s/exec", *manager, taskID)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error preparing request: %v\n", err)
+		return 1
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", task.ExecUpgrade)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error connecting to manager: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		msg, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "exec failed (%d): %s\n", resp.StatusCode, bytes.TrimSpace(msg))
+		return 1
+	}
+	conn := resp.Body.(io.ReadWriteCloser)
+
+	if fd := int(os.Stdin.Fd()); *tty && term.IsTerminal(fd) {
+		// keys like Ctrl-C and arrows go to the command instead of the local terminal
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error switching the terminal to raw mode: %v\n", err)
+			return 1
+		}
+		defer func() { _ = term.Restore(fd, state) }()
+	}
+
+	go func() {
+		stdin := task.ExecFrameWriter{W: conn, Kind: task.ExecStdin}
+		_, _ = io.Copy(stdin, os.Stdin)
+		_ = task.WriteExecFrame(conn, task.ExecStdinClose, nil)
+	}()
+
+	for {
+		kind, payload, err := task.ReadExecFrame(conn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "connection closed before the command finished: %v\n", err)
+			return 1
+		}
+		switch kind {
+		case task.ExecStdout:
+			_, _ = os.Stdout.Write(payload)
+		case task.ExecStderr:
+			_, _ = os.Stderr.Write(payload)
+		case task.ExecExit:
+			result := task.ExecResult{}
+			if err := json.Unmarshal(payload, &result); err != nil {
+				fmt.Fprintf(os.Stderr, "malformed exit status: %v\n", err)
+				return 1
+			}
+			if result.Error != "" {
+				fmt.Fprintf(os.Stderr, "exec error: %s\n", result.Error)
+				return 1
+			}
+			return result.ExitCode
+		}
+	}
+}
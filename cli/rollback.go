@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+const rollbackUsage = "rollback [-manager host:port] <service ID>"
+
+func rollbackCommand(args []string) int {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	manager := fs.String("manager", defaultManager, "manager API address")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: cube %s\n", rollbackUsage)
+		return 2
+	}
+	serviceID, err := uuid.Parse(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid service ID %q\n", fs.Arg(0))
+		return 2
+	}
+
+	url := fmt.Sprintf("http://%s/services/%s/rollback", *manager, serviceID)
+	resp, err := http.Post(url, "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error connecting to manager: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "rollback failed (%d): %s\n", resp.StatusCode, bytes.TrimSpace(body))
+		return 1
+	}
+	fmt.Printf("%s\n", bytes.TrimSpace(body))
+	return 0
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Config holds the contents of a non-sensitive file that tasks mount.
+// Every change produces a new version.
+type Config struct {
+	Name      string
+	Data      string
+	Labels    map[string]string
+	Version   int
+	CreatedAt time.Time // of this version
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid config name %q, use letters, digits, '_', '.' and '-'", name)
+	}
+	return nil
+}
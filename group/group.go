@@ -0,0 +1,188 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/task"
+)
+
+// states of a group
+const (
+	Pending      = "pending"      // waiting for a node with room for every container
+	Initializing = "initializing" // init containers run one after another
+	Starting     = "starting"     // containers start in the declared order
+	Running      = "running"      // every container is running
+	Failed       = "failed"
+	Stopped      = "stopped"
+)
+
+// states of a container of a group
+const (
+	Waiting   = "waiting"
+	Started   = "started"
+	Succeeded = "succeeded" // init container exited cleanly
+	Skipped   = "skipped"   // never started, because the group failed or stopped first
+)
+
+// image of the container holding the network namespace of a group
+const DefaultInfraImage = "registry.k8s.io/pause:3.9"
+
+// Group is a set of containers scheduled together on one node, sharing its
+// network namespace and volumes
+type Group struct {
+	ID             uuid.UUID
+	Name           string
+	Labels         map[string]string
+	Priority       int
+	InfraImage     string      // DefaultInfraImage when not set
+	InitContainers []Container // run to completion one after another before Containers start
+	Containers     []Container // started in the declared order, each once the previous one runs
+	InfraTaskID    uuid.UUID
+	State          string
+	Reason         string
+	CreatedAt      time.Time
+	Finished       time.Time
+}
+
+type Container struct {
+	Name   string
+	Task   task.Task
+	State  string
+	Reason string
+}
+
+func (g *Group) Validate() error {
+	if g.Name == "" {
+		return errors.New("group name is required")
+	}
+	if len(g.Containers) == 0 {
+		return errors.New("group needs at least one container")
+	}
+	names := make(map[string]bool)
+	for _, c := range g.All() {
+		if c.Name == "" {
+			return errors.New("every container needs a name")
+		}
+		if names[c.Name] {
+			return fmt.Errorf("container %s is declared twice", c.Name)
+		}
+		names[c.Name] = true
+		if c.Task.Image == "" {
+			return fmt.Errorf("container %s needs an image", c.Name)
+		}
+		if len(c.Task.LocalVolumes()) > 0 {
+			return fmt.Errorf("container %s mounts local volumes, which groups don't support", c.Name)
+		}
+		if err := c.Task.Validate(); err != nil {
+			return fmt.Errorf("container %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// All lists init containers followed by the rest, in the order they start
+func (g *Group) All() []*Container {
+	all := make([]*Container, 0, len(g.InitContainers)+len(g.Containers))
+	for i := range g.InitContainers {
+		all = append(all, &g.InitContainers[i])
+	}
+	for i := range g.Containers {
+		all = append(all, &g.Containers[i])
+	}
+	return all
+}
+
+// Prepare gives every container a fresh task owned by the group and returns
+// the infra task, which holds the network namespace, the published ports and
+// the resources of the whole group
+func (g *Group) Prepare() task.Task {
+	if g.InfraImage == "" {
+		g.InfraImage = DefaultInfraImage
+	}
+	g.State = Pending
+	infra := task.Task{
+		ID:           uuid.New(),
+		State:        task.Pending,
+		Labels:       g.labels(nil),
+		GroupID:      g.ID,
+		Priority:     g.Priority,
+		Image:        g.InfraImage,
+		ExposedPorts: nat.PortSet{},
+		PortBindings: map[string]string{},
+	}
+	infra.Name = fmt.Sprintf("%s-infra-%s", g.Name, infra.ID.String()[:8])
+	g.InfraTaskID = infra.ID
+
+	var initMax, sum task.Task
+	for i := range g.InitContainers {
+		c := &g.InitContainers[i]
+		g.prepareContainer(c, infra.ID)
+		c.Task.Init = true
+		initMax.Cpu = max(initMax.Cpu, c.Task.Cpu)
+		initMax.Memory = max(initMax.Memory, c.Task.Memory)
+		initMax.Disk = max(initMax.Disk, c.Task.Disk)
+	}
+	for i := range g.Containers {
+		c := &g.Containers[i]
+		// ports can only be published by the container owning the network
+		for p := range c.Task.ExposedPorts {
+			infra.ExposedPorts[p] = struct{}{}
+		}
+		for k, v := range c.Task.PortBindings {
+			infra.PortBindings[k] = v
+		}
+		g.prepareContainer(c, infra.ID)
+		sum.Cpu += c.Task.Cpu
+		sum.Memory += c.Task.Memory
+		sum.Disk += c.Task.Disk
+	}
+	// init containers are done before the rest start, so the larger of the two counts
+	infra.Cpu = max(initMax.Cpu, sum.Cpu)
+	infra.Memory = max(initMax.Memory, sum.Memory)
+	infra.Disk = max(initMax.Disk, sum.Disk)
+	return infra
+}
+
+func (g *Group) prepareContainer(c *Container, infraID uuid.UUID) {
+	t := &c.Task
+	t.ID = uuid.New()
+	t.Name = fmt.Sprintf("%s-%s-%s", g.Name, c.Name, t.ID.String()[:8])
+	t.State = task.Pending
+	t.Labels = g.labels(t.Labels)
+	t.GroupID = g.ID
+	t.InfraTaskID = infraID
+	t.Priority = g.Priority
+	t.ExposedPorts = nil
+	t.PortBindings = nil
+	// the group restarts nothing, a failing container fails it
+	t.RestartPolicy = ""
+	mounts := make([]task.Mount, len(t.Mounts))
+	for i, m := range t.Mounts {
+		if m.Type == task.MountVolume {
+			// named volumes are shared within the group, and only within it
+			m.Source = fmt.Sprintf("%s-%s-%s", g.Name, g.ID.String()[:8], m.Source)
+		}
+		mounts[i] = m
+	}
+	t.Mounts = mounts
+	c.State = Waiting
+}
+
+func (g *Group) labels(own map[string]string) map[string]string {
+	labels := make(map[string]string)
+	for k, v := range own {
+		labels[k] = v
+	}
+	for k, v := range g.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+func IsFinished(state string) bool {
+	return state == Failed || state == Stopped
+}
@@ -0,0 +1,146 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// concurrency policies of a cron job
+const (
+	AllowConcurrent   = "allow"   // runs may overlap
+	ForbidConcurrent  = "forbid"  // a run is skipped while the previous one is active
+	ReplaceConcurrent = "replace" // the active run is stopped and replaced by the new one
+)
+
+// upper bound of missed runs started at once when catching up
+const maxCatchUpRuns = 100
+
+// CronJob creates a Job out of JobTemplate every time Schedule fires
+type CronJob struct {
+	ID                         uuid.UUID
+	Name                       string
+	Schedule                   string // standard 5-field cron expression or a macro like @hourly
+	TimeZone                   string // IANA name, UTC when not set
+	ConcurrencyPolicy          string // allow when not set
+	StartingDeadlineSeconds    int    // how late a missed run may still start, unlimited when not set
+	CatchUp                    bool   // start every missed run instead of only the most recent one
+	SuccessfulJobsHistoryLimit *int   // finished jobs kept around, 3 and 1 when not set
+	FailedJobsHistoryLimit     *int
+	Suspend                    bool
+	JobTemplate                Job
+	Status                     CronJobStatus
+	CreatedAt                  time.Time
+}
+
+type CronJobStatus struct {
+	Active             []uuid.UUID
+	LastScheduleTime   time.Time
+	LastSuccessfulTime time.Time
+}
+
+func (cj *CronJob) Validate() error {
+	if cj.Name == "" {
+		return errors.New("cron job name is required")
+	}
+	if _, err := ParseSchedule(cj.Schedule); err != nil {
+		return err
+	}
+	if _, err := cj.Location(); err != nil {
+		return err
+	}
+	switch cj.ConcurrencyPolicy {
+	case "", AllowConcurrent, ForbidConcurrent, ReplaceConcurrent:
+	default:
+		return fmt.Errorf("unknown concurrency policy %q", cj.ConcurrencyPolicy)
+	}
+	if cj.StartingDeadlineSeconds < 0 {
+		return errors.New("starting deadline can't be negative")
+	}
+	if (cj.SuccessfulJobsHistoryLimit != nil && *cj.SuccessfulJobsHistoryLimit < 0) ||
+		(cj.FailedJobsHistoryLimit != nil && *cj.FailedJobsHistoryLimit < 0) {
+		return errors.New("history limits can't be negative")
+	}
+	// the name is filled in for every run
+	template := cj.JobTemplate
+	template.Name = cj.Name
+	return template.Validate()
+}
+
+func (cj *CronJob) SetDefaults() {
+	if cj.ConcurrencyPolicy == "" {
+		cj.ConcurrencyPolicy = AllowConcurrent
+	}
+	if cj.SuccessfulJobsHistoryLimit == nil {
+		limit := 3
+		cj.SuccessfulJobsHistoryLimit = &limit
+	}
+	if cj.FailedJobsHistoryLimit == nil {
+		limit := 1
+		cj.FailedJobsHistoryLimit = &limit
+	}
+}
+
+func (cj *CronJob) Location() (*time.Location, error) {
+	if cj.TimeZone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(cj.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", cj.TimeZone)
+	}
+	return loc, nil
+}
+
+// DueRuns lists schedule times between the last run and now which should be
+// started, honouring the starting deadline and the catch-up setting
+func (cj *CronJob) DueRuns(now time.Time) ([]time.Time, error) {
+	schedule, err := ParseSchedule(cj.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := cj.Location()
+	if err != nil {
+		return nil, err
+	}
+	since := cj.Status.LastScheduleTime
+	if since.IsZero() {
+		since = cj.CreatedAt
+	}
+	earliest := time.Time{}
+	if cj.StartingDeadlineSeconds > 0 {
+		earliest = now.Add(-time.Duration(cj.StartingDeadlineSeconds) * time.Second)
+		// no point in walking through runs that are too late anyway
+		if since.Before(earliest) {
+			since = earliest.Add(-time.Minute)
+		}
+	}
+
+	var runs []time.Time
+	for t := schedule.Next(since.In(loc)); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+		if t.Before(earliest) {
+			continue
+		}
+		runs = append(runs, t)
+		if len(runs) > maxCatchUpRuns {
+			runs = runs[1:]
+		}
+	}
+	if !cj.CatchUp && len(runs) > 1 {
+		runs = runs[len(runs)-1:]
+	}
+	return runs, nil
+}
+
+// NewJob creates the run of the cron job scheduled at the given time
+func (cj *CronJob) NewJob(scheduled time.Time) Job {
+	j := cj.JobTemplate
+	j.ID = uuid.New()
+	j.Name = fmt.Sprintf("%s-%d", cj.Name, scheduled.Unix()/60)
+	j.CronJobID = cj.ID
+	j.ScheduledTime = scheduled.UTC()
+	j.Status = Status{}
+	return j
+}
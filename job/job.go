@@ -0,0 +1,109 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/task"
+)
+
+// states of a job
+const (
+	Active    = "active"
+	Succeeded = "succeeded"
+	Failed    = "failed"
+)
+
+// reasons for a job failure
+const (
+	BackoffLimitExceeded = "BackoffLimitExceeded"
+	DeadlineExceeded     = "DeadlineExceeded"
+	Replaced             = "Replaced" // a newer run of its cron job took over
+)
+
+// Job runs tasks created from Template until enough of them exit successfully
+type Job struct {
+	ID                    uuid.UUID
+	Name                  string
+	Labels                map[string]string
+	Template              task.Task
+	Completions           int       // successful runs needed, 1 when not set
+	Parallelism           int       // runs allowed at the same time, 1 when not set
+	BackoffLimit          int       // failed runs tolerated before the job fails
+	ActiveDeadlineSeconds int       // time the job may stay active, unlimited when not set
+	CronJobID             uuid.UUID // set when the job is a run of a cron job
+	ScheduledTime         time.Time
+	Status                Status
+	CreatedAt             time.Time
+}
+
+type Status struct {
+	State          string
+	Reason         string
+	Active         int
+	Succeeded      int
+	Failed         int
+	StartTime      time.Time
+	CompletionTime time.Time
+}
+
+func (j *Job) Validate() error {
+	if j.Name == "" {
+		return errors.New("job name is required")
+	}
+	if j.Template.Image == "" {
+		return errors.New("task template needs an image")
+	}
+	if j.Completions < 0 || j.Parallelism < 0 || j.BackoffLimit < 0 || j.ActiveDeadlineSeconds < 0 {
+		return errors.New("completions, parallelism, backoff limit and deadline can't be negative")
+	}
+	return j.Template.Validate()
+}
+
+func (j *Job) SetDefaults() {
+	if j.Completions == 0 {
+		j.Completions = 1
+	}
+	if j.Parallelism == 0 {
+		j.Parallelism = 1
+	}
+}
+
+func (j *Job) IsFinished() bool {
+	return j.Status.State == Succeeded || j.Status.State == Failed
+}
+
+func (j *Job) DeadlineExceeded(now time.Time) bool {
+	if j.ActiveDeadlineSeconds == 0 || j.Status.StartTime.IsZero() {
+		return false
+	}
+	return now.Sub(j.Status.StartTime) > time.Duration(j.ActiveDeadlineSeconds)*time.Second
+}
+
+// NewTask creates a single run of the job out of the template
+func (j *Job) NewTask() task.Task {
+	t := j.Template
+	t.ID = uuid.New()
+	// container names have to be unique on a worker
+	t.Name = fmt.Sprintf("%s-%s", j.Name, t.ID.String()[:8])
+	t.State = task.Pending
+	t.JobID = j.ID
+	t.Labels = make(map[string]string)
+	for k, v := range j.Template.Labels {
+		t.Labels[k] = v
+	}
+	for k, v := range j.Labels {
+		t.Labels[k] = v
+	}
+	t.ContainerID = ""
+	t.HostPorts = nil
+	t.StartTime = time.Time{}
+	t.FinishTime = time.Time{}
+	t.ExitCode = 0
+	t.RestartCount = 0
+	// restarts are the job's business, not docker's
+	t.RestartPolicy = ""
+	return t
+}
@@ -2,22 +2,46 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
 
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/cli"
 	"kjarmicki.github.com/cube/manager"
+	"kjarmicki.github.com/cube/secret"
 	"kjarmicki.github.com/cube/task"
 	"kjarmicki.github.com/cube/worker"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
+
+	dataDir := os.Getenv("CUBE_DATA_DIR")
+	if dataDir == "" {
+		dataDir = "/var/lib/cube"
+	}
+
 	host := "localhost"
 	mport := 3030
 	wport := 3031
 
 	w := worker.Worker{
-		Queue: *queue.New(),
-		Db:    make(map[uuid.UUID]*task.Task),
+		Queue:            *queue.New(),
+		Db:               make(map[uuid.UUID]*task.Task),
+		AllowedHostPaths: filepath.SplitList(os.Getenv("CUBE_ALLOWED_HOST_PATHS")),
+		DataDir:          dataDir,
+		SecretsDir:       "/dev/shm/cube-secrets",
+	}
+	if threshold := os.Getenv("CUBE_IMAGE_GC_THRESHOLD"); threshold != "" {
+		var err error
+		if w.ImageGCThreshold, err = strconv.Atoi(threshold); err != nil {
+			log.Fatalf("Invalid image garbage collection threshold %q: %v", threshold, err)
+		}
 	}
 	wapi := worker.Api{
 		Address: host,
@@ -27,15 +51,39 @@ func main() {
 
 	go w.RunTasks()
 	go w.UpdateTasks()
+	go w.CollectStats()
+	go w.CollectImageGarbage()
 	go wapi.Start()
 
 	workers := []string{fmt.Sprintf("%s:%d", host, wport)}
 	m := manager.New(workers, "roundrobin")
+	m.Preemption = os.Getenv("CUBE_PREEMPTION") == "true"
+	if keyFile := os.Getenv("CUBE_SECRETS_KEY_FILE"); keyFile != "" {
+		key, err := secret.LoadKey(keyFile)
+		if err != nil {
+			log.Fatalf("Error loading secrets key: %v", err)
+		}
+		m.Secrets, err = secret.NewStore(key, filepath.Join(dataDir, "secrets.json"))
+		if err != nil {
+			log.Fatalf("Error opening secrets store: %v", err)
+		}
+		m.Registries, err = secret.NewStore(key, filepath.Join(dataDir, "registries.json"))
+		if err != nil {
+			log.Fatalf("Error opening registries store: %v", err)
+		}
+	}
 	mapi := manager.Api{Address: host, Port: mport, Manager: m}
 
 	go m.ProcessTasks()
 	go m.UpdateTasks()
+	go m.UpdateNodes()
 	go m.DoHealthChecks()
+	go m.ReconcileServices()
+	go m.ReconcileJobs()
+	go m.ReconcileCronJobs()
+	go m.ReconcileWorkflows()
+	go m.ReconcileGroups()
+	go m.ReconcileConfigs()
 
 	mapi.Start()
 
@@ -0,0 +1,247 @@
+package manager
+
+import (
+	"log"
+	"sort"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/job"
+	"kjarmicki.github.com/cube/manifest"
+	"kjarmicki.github.com/cube/service"
+	"kjarmicki.github.com/cube/task"
+)
+
+// Apply brings managed resources in line with the manifest. With dryRun it only
+// returns the plan; with prune, managed resources missing from the manifest are removed.
+func (m *Manager) Apply(mf manifest.Manifest, prune bool, dryRun bool) (manifest.Plan, error) {
+	if err := mf.Validate(); err != nil {
+		return manifest.Plan{}, err
+	}
+	plan := manifest.Plan{}
+	plan.Actions = append(plan.Actions, m.planTasks(mf.Tasks, prune, dryRun)...)
+	plan.Actions = append(plan.Actions, m.planServices(mf.Services, prune, dryRun)...)
+	plan.Actions = append(plan.Actions, m.planJobs(mf.Jobs, prune, dryRun)...)
+	err := plan.Err()
+	plan.Applied = !dryRun && err == nil
+	if err != nil {
+		log.Printf("[Manager] Applying manifest failed: %v\n", err)
+	} else if !dryRun {
+		log.Printf("[Manager] Applied manifest with %d actions\n", len(plan.Actions))
+	}
+	return plan, nil
+}
+
+// fail records why an action couldn't be carried out, if it couldn't
+func fail(a *manifest.Action, err error) {
+	if err != nil && a.Error == "" {
+		a.Error = err.Error()
+	}
+}
+
+// standalone tasks created by apply, by the name they were declared with
+func (m *Manager) managedTasks() map[string]*task.Task {
+	tasks := make(map[string]*task.Task)
+	for _, t := range m.TaskDb {
+		if t.ServiceID != uuid.Nil || t.JobID != uuid.Nil || t.WorkflowID != uuid.Nil {
+			continue
+		}
+		if manifest.IsManaged(t.Labels) && m.isTaskLive(t) {
+			tasks[manifest.DeclaredName(*t)] = t
+		}
+	}
+	return tasks
+}
+
+func (m *Manager) planTasks(desired []task.Task, prune bool, dryRun bool) []manifest.Action {
+	var actions []manifest.Action
+	current := m.managedTasks()
+	for _, d := range desired {
+		spec := manifest.TaskSpec(d)
+		a := manifest.Action{Kind: manifest.KindTask, Name: d.Name, Action: manifest.Create}
+		existing, ok := current[d.Name]
+		delete(current, d.Name)
+		if ok {
+			a.Changes = manifest.Diff(manifest.TaskSpec(*existing), spec)
+			if len(a.Changes) == 0 {
+				a.Action = manifest.Unchanged
+				actions = append(actions, a)
+				continue
+			}
+			// a container can't be changed in place
+			a.Action = manifest.Replace
+			if !dryRun {
+				m.stopTask(existing, "replaced by apply")
+			}
+		}
+		if !dryRun {
+			spec.ID = uuid.New()
+			spec.State = task.Pending
+			m.startTask(spec)
+		}
+		actions = append(actions, a)
+	}
+	if prune {
+		for _, name := range sortedNames(current) {
+			actions = append(actions, manifest.Action{Kind: manifest.KindTask, Name: name, Action: manifest.Delete})
+			if !dryRun {
+				m.stopTask(current[name], "pruned by apply")
+			}
+		}
+	}
+	return actions
+}
+
+func serviceSpec(s service.Service) service.Service {
+	if s.Strategy == (service.UpdateStrategy{}) {
+		s.Strategy = service.DefaultStrategy
+	}
+	return service.Service{
+		Name:     s.Name,
+		Replicas: s.Replicas,
+		Labels:   manifest.Managed(s.Labels),
+		Template: manifest.TemplateSpec(s.Template),
+		Strategy: s.Strategy,
+	}
+}
+
+func (m *Manager) planServices(desired []service.Service, prune bool, dryRun bool) []manifest.Action {
+	var actions []manifest.Action
+	current := make(map[string]*service.Service)
+	unmanaged := make(map[string]bool)
+	for _, s := range m.ServiceDb {
+		if manifest.IsManaged(s.Labels) {
+			current[s.Name] = s
+		} else {
+			unmanaged[s.Name] = true
+		}
+	}
+	for _, d := range desired {
+		spec := serviceSpec(d)
+		a := manifest.Action{Kind: manifest.KindService, Name: d.Name, Action: manifest.Create}
+		existing, ok := current[d.Name]
+		delete(current, d.Name)
+		switch {
+		case unmanaged[d.Name]:
+			// adopting a service created by hand would make prune remove it later on
+			a.Action = manifest.Unchanged
+			a.Changes = []string{"not managed by apply"}
+		case !ok:
+			if !dryRun {
+				_, err := m.AddService(spec)
+				fail(&a, err)
+			}
+		default:
+			a.Changes = manifest.Diff(serviceSpec(*existing), spec)
+			if len(a.Changes) == 0 {
+				a.Action = manifest.Unchanged
+				break
+			}
+			a.Action = manifest.Update
+			if !dryRun {
+				fail(&a, m.updateManagedService(existing, spec, a.Changes))
+			}
+		}
+		actions = append(actions, a)
+	}
+	if prune {
+		for _, name := range sortedNames(current) {
+			a := manifest.Action{Kind: manifest.KindService, Name: name, Action: manifest.Delete}
+			if !dryRun {
+				fail(&a, m.RemoveService(current[name].ID))
+			}
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+func (m *Manager) updateManagedService(s *service.Service, spec service.Service, changes []string) error {
+	for _, field := range changes {
+		var err error
+		switch field {
+		case "Replicas":
+			_, err = m.ScaleService(s.ID, spec.Replicas)
+		case "Labels":
+			s.Labels = spec.Labels
+		case "Strategy":
+			s.Strategy = spec.Strategy
+		case "Template":
+			_, err = m.UpdateService(s.ID, spec.Template, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func jobSpec(j job.Job) job.Job {
+	j.SetDefaults()
+	return job.Job{
+		Name:                  j.Name,
+		Labels:                manifest.Managed(j.Labels),
+		Template:              manifest.TemplateSpec(j.Template),
+		Completions:           j.Completions,
+		Parallelism:           j.Parallelism,
+		BackoffLimit:          j.BackoffLimit,
+		ActiveDeadlineSeconds: j.ActiveDeadlineSeconds,
+	}
+}
+
+// a job that already ran with the same spec is left alone, even when it has finished;
+// a job with a different spec is removed and created again
+func (m *Manager) planJobs(desired []job.Job, prune bool, dryRun bool) []manifest.Action {
+	var actions []manifest.Action
+	current := make(map[string]*job.Job)
+	for _, j := range m.JobDb {
+		if j.CronJobID == uuid.Nil && manifest.IsManaged(j.Labels) {
+			current[j.Name] = j
+		}
+	}
+	for _, d := range desired {
+		spec := jobSpec(d)
+		a := manifest.Action{Kind: manifest.KindJob, Name: d.Name, Action: manifest.Create}
+		existing, ok := current[d.Name]
+		delete(current, d.Name)
+		if ok {
+			a.Changes = manifest.Diff(jobSpec(*existing), spec)
+			if len(a.Changes) == 0 {
+				a.Action = manifest.Unchanged
+				actions = append(actions, a)
+				continue
+			}
+			a.Action = manifest.Replace
+			if !dryRun {
+				if err := m.RemoveJob(existing.ID); err != nil {
+					fail(&a, err)
+					actions = append(actions, a)
+					continue
+				}
+			}
+		}
+		if !dryRun {
+			_, err := m.AddJob(spec)
+			fail(&a, err)
+		}
+		actions = append(actions, a)
+	}
+	if prune {
+		for _, name := range sortedNames(current) {
+			a := manifest.Action{Kind: manifest.KindJob, Name: name, Action: manifest.Delete}
+			if !dryRun {
+				fail(&a, m.RemoveJob(current[name].ID))
+			}
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+func sortedNames[T any](resources map[string]T) []string {
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
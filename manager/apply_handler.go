@@ -0,0 +1,53 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"kjarmicki.github.com/cube/manifest"
+)
+
+// ApplyHandler takes a manifest in JSON or YAML and responds with the plan,
+// applying it unless dryRun is set. When some action fails the plan comes
+// with a 500, telling which.
+func (a *Api) ApplyHandler(w http.ResponseWriter, r *http.Request) {
+	var flags [2]bool
+	for i, name := range []string{"prune", "dryRun"} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, 400, fmt.Sprintf("Invalid %s value %q", name, v))
+			return
+		}
+		flags[i] = b
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error reading body: %v", err))
+		return
+	}
+	mf, err := manifest.Parse(data)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid manifest: %v", err))
+		return
+	}
+	plan, err := a.Manager.Apply(mf, flags[0], flags[1])
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid manifest: %v", err))
+		return
+	}
+
+	status := 200
+	if plan.Err() != nil {
+		status = 500
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(plan)
+}
@@ -0,0 +1,107 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"kjarmicki.github.com/cube/config"
+)
+
+type ConfigUpdateRequest struct {
+	Data string
+}
+
+func (a *Api) CreateConfigHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	c := config.Config{}
+	if err := d.Decode(&c); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	created, err := a.Manager.AddConfig(c)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid config: %v", err))
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(created)
+}
+
+func (a *Api) GetConfigsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.GetConfigs())
+}
+
+func (a *Api) GetConfigHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.lookupConfig(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(c)
+}
+
+func (a *Api) UpdateConfigHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.lookupConfig(w, r)
+	if !ok {
+		return
+	}
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := ConfigUpdateRequest{}
+	if err := d.Decode(&req); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	updated, err := a.Manager.UpdateConfig(c.Name, req.Data)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(updated)
+}
+
+func (a *Api) DeleteConfigHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.lookupConfig(w, r)
+	if !ok {
+		return
+	}
+	if err := a.Manager.RemoveConfig(c.Name); err != nil {
+		writeError(w, 409, err.Error())
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (a *Api) GetConfigVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.lookupConfig(w, r)
+	if !ok {
+		return
+	}
+	versions, _ := a.Manager.GetConfigVersions(c.Name)
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(versions)
+}
+
+func (a *Api) lookupConfig(w http.ResponseWriter, r *http.Request) (*config.Config, bool) {
+	name := chi.URLParam(r, "name")
+	c, ok := a.Manager.ConfigDb[name]
+	if !ok {
+		writeError(w, 404, fmt.Sprintf("Config %s not found", name))
+		return nil, false
+	}
+	return c, true
+}
@@ -0,0 +1,218 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/config"
+	"kjarmicki.github.com/cube/service"
+	"kjarmicki.github.com/cube/task"
+)
+
+// number of versions kept for each config
+const configHistoryLimit = 10
+
+func (m *Manager) AddConfig(c config.Config) (*config.Config, error) {
+	if err := config.ValidateName(c.Name); err != nil {
+		return nil, err
+	}
+	if _, ok := m.ConfigDb[c.Name]; ok {
+		return nil, fmt.Errorf("config %s already exists", c.Name)
+	}
+	c.Version = 1
+	c.CreatedAt = time.Now().UTC()
+	m.ConfigDb[c.Name] = &c
+	m.recordConfigVersion(&c)
+	log.Printf("[Manager] Added config %s\n", c.Name)
+	return &c, nil
+}
+
+func (m *Manager) GetConfigs() []*config.Config {
+	configs := make([]*config.Config, 0, len(m.ConfigDb))
+	for _, c := range m.ConfigDb {
+		configs = append(configs, c)
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].Name < configs[j].Name
+	})
+	return configs
+}
+
+// UpdateConfig stores a new version and restarts the tasks using the config,
+// services through a rolling update and standalone tasks one by one
+func (m *Manager) UpdateConfig(name string, data string) (*config.Config, error) {
+	c, ok := m.ConfigDb[name]
+	if !ok {
+		return nil, fmt.Errorf("config %s not found", name)
+	}
+	if c.Data == data {
+		return c, nil
+	}
+	c.Data = data
+	c.Version++
+	c.CreatedAt = time.Now().UTC()
+	m.recordConfigVersion(c)
+	log.Printf("[Manager] Updated config %s to version %d\n", c.Name, c.Version)
+
+	reason := fmt.Sprintf("config %s changed to version %d", c.Name, c.Version)
+	for _, s := range m.GetServices() {
+		if s.Update.State == service.UpdatePaused {
+			// the current template is the one that failed, what runs is the stable one
+			stable, ok := m.findRevision(s.ID, s.StableRevision)
+			if ok && stable.Template.UsesConfig(c.Name) {
+				m.startRollout(s, stable.Template, fmt.Sprintf("%s, on revision %d", reason, stable.Number))
+			}
+			continue
+		}
+		if s.Template.UsesConfig(c.Name) {
+			m.startRollout(s, s.Template, reason)
+		}
+	}
+	return c, nil
+}
+
+func (m *Manager) recordConfigVersion(c *config.Config) {
+	versions := append(m.ConfigVersions[c.Name], *c)
+	if len(versions) > configHistoryLimit {
+		versions = versions[len(versions)-configHistoryLimit:]
+	}
+	m.ConfigVersions[c.Name] = versions
+}
+
+func (m *Manager) GetConfigVersions(name string) ([]config.Config, bool) {
+	if _, ok := m.ConfigDb[name]; !ok {
+		return nil, false
+	}
+	return m.ConfigVersions[name], true
+}
+
+// RemoveConfig deletes a config no task or service uses
+func (m *Manager) RemoveConfig(name string) error {
+	if _, ok := m.ConfigDb[name]; !ok {
+		return fmt.Errorf("config %s not found", name)
+	}
+	for _, t := range m.TaskDb {
+		if m.isTaskLive(t) && t.UsesConfig(name) {
+			return fmt.Errorf("config %s is used by task %s", name, t.ID)
+		}
+	}
+	for _, s := range m.ServiceDb {
+		if s.Template.UsesConfig(name) {
+			return fmt.Errorf("config %s is used by service %s", name, s.Name)
+		}
+	}
+	delete(m.ConfigDb, name)
+	delete(m.ConfigVersions, name)
+	log.Printf("[Manager] Removed config %s\n", name)
+	return nil
+}
+
+// resolveConfigs pins the task to the current versions of its configs and returns their contents
+func (m *Manager) resolveConfigs(t *task.Task) (map[string][]byte, error) {
+	if len(t.Configs) == 0 {
+		return nil, nil
+	}
+	// the slice may be shared with the template the task was made from
+	refs := append([]task.ConfigRef(nil), t.Configs...)
+	contents := make(map[string][]byte)
+	for i, r := range refs {
+		c, ok := m.ConfigDb[r.Name]
+		if !ok {
+			return nil, fmt.Errorf("config %s not found", r.Name)
+		}
+		refs[i].Version = c.Version
+		contents[r.Name] = []byte(c.Data)
+	}
+	t.Configs = refs
+	return contents, nil
+}
+
+// tells whether the task was started with a config version that has been replaced since
+func (m *Manager) hasStaleConfig(t *task.Task) (string, bool) {
+	for _, r := range t.Configs {
+		if c, ok := m.ConfigDb[r.Name]; ok && r.Version != 0 && r.Version < c.Version {
+			return fmt.Sprintf("config %s changed to version %d", c.Name, c.Version), true
+		}
+	}
+	return "", false
+}
+
+func (m *Manager) ReconcileConfigs() {
+	for {
+		log.Println("[Manager] Restarting tasks with outdated configs")
+		m.locked(m.reconcileConfigs)
+		log.Println("[Manager] Configs reconciled, sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// replaces standalone tasks started with an outdated config, one at a time: the
+// next one goes only once the previous replacement is no longer waiting to run. Replicas are up
+// to their service, and batch tasks pick up the new version on their next run.
+// Group containers share their infra task and can't be swapped on their own.
+func (m *Manager) reconcileConfigs() {
+	if t, ok := m.TaskDb[m.replacing]; ok && (t.State == task.Pending || t.State == task.Scheduled) {
+		return
+	}
+	m.replacing = uuid.Nil
+	var stale []*task.Task
+	for _, t := range m.TaskDb {
+		if len(t.Configs) == 0 || t.ServiceID != uuid.Nil || t.GroupID != uuid.Nil || t.RunsToCompletion() || !m.isTaskLive(t) {
+			continue
+		}
+		if _, ok := m.hasStaleConfig(t); ok {
+			stale = append(stale, t)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].ID.String() < stale[j].ID.String()
+	})
+	old := stale[0]
+	reason, _ := m.hasStaleConfig(old)
+	replacement := *old
+	replacement.ID = uuid.New()
+	replacement.Name = replacementName(old, replacement.ID)
+	replacement.State = task.Pending
+	replacement.Configs = unpinnedConfigs(old.Configs)
+	replacement.PendingReason = ""
+	replacement.Substatus = ""
+	replacement.PullProgress = nil
+	replacement.ContainerID = ""
+	replacement.HostPorts = nil
+	replacement.StartTime = time.Time{}
+	replacement.FinishTime = time.Time{}
+	replacement.ExitCode = 0
+	replacement.FailureReason = ""
+	replacement.FailureMessage = ""
+	replacement.RestartCount = 0
+	log.Printf("[Manager] Replacing task %s with %s: %s\n", old.ID, replacement.ID, reason)
+	m.stopTask(old, reason)
+	m.startTask(replacement)
+	m.replacing = replacement.ID
+}
+
+// container names have to be unique on a worker, and the old one may still
+// hold its name while it's stopping
+func replacementName(old *task.Task, id uuid.UUID) string {
+	if old.Name == "" {
+		return ""
+	}
+	base := strings.TrimSuffix(old.Name, "-"+old.ID.String()[:8])
+	return fmt.Sprintf("%s-%s", base, id.String()[:8])
+}
+
+func unpinnedConfigs(refs []task.ConfigRef) []task.ConfigRef {
+	unpinned := make([]task.ConfigRef, 0, len(refs))
+	for _, r := range refs {
+		r.Version = 0
+		unpinned = append(unpinned, r)
+	}
+	return unpinned
+}
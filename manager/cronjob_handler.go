@@ -0,0 +1,99 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/job"
+)
+
+func (a *Api) CreateCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	cj := job.CronJob{}
+	if err := d.Decode(&cj); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	created, err := a.Manager.AddCronJob(cj)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid cron job: %v", err))
+		return
+	}
+
+	log.Printf("Added cron job %s\n", created.ID)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(created)
+}
+
+func (a *Api) GetCronJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.GetCronJobs())
+}
+
+func (a *Api) GetCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	cj, ok := a.lookupCronJob(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(cj)
+}
+
+func (a *Api) SuspendCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	a.setCronJobSuspended(w, r, true)
+}
+
+func (a *Api) ResumeCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	a.setCronJobSuspended(w, r, false)
+}
+
+func (a *Api) setCronJobSuspended(w http.ResponseWriter, r *http.Request, suspend bool) {
+	cj, ok := a.lookupCronJob(w, r)
+	if !ok {
+		return
+	}
+	cj, err := a.Manager.SuspendCronJob(cj.ID, suspend)
+	if err != nil {
+		writeError(w, 404, err.Error())
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(cj)
+}
+
+func (a *Api) DeleteCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	cj, ok := a.lookupCronJob(w, r)
+	if !ok {
+		return
+	}
+	if err := a.Manager.RemoveCronJob(cj.ID); err != nil {
+		writeError(w, 404, err.Error())
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (a *Api) lookupCronJob(w http.ResponseWriter, r *http.Request) (*job.CronJob, bool) {
+	cjID, err := uuid.Parse(chi.URLParam(r, "cronJobID"))
+	if err != nil {
+		writeError(w, 400, "CronJobID passed in the request looks invalid")
+		return nil, false
+	}
+	cj, ok := a.Manager.CronJobDb[cjID]
+	if !ok {
+		writeError(w, 404, fmt.Sprintf("Cron job %s not found", cjID))
+		return nil, false
+	}
+	return cj, true
+}
@@ -0,0 +1,161 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/job"
+)
+
+func (m *Manager) AddCronJob(cj job.CronJob) (*job.CronJob, error) {
+	if err := cj.Validate(); err != nil {
+		return nil, err
+	}
+	cj.SetDefaults()
+	if cj.ID == uuid.Nil {
+		cj.ID = uuid.New()
+	}
+	cj.CreatedAt = time.Now().UTC()
+	cj.Status = job.CronJobStatus{}
+	m.CronJobDb[cj.ID] = &cj
+	log.Printf("[Manager] Added cron job %s (%s) scheduled at %q\n", cj.Name, cj.ID, cj.Schedule)
+	return &cj, nil
+}
+
+func (m *Manager) GetCronJobs() []*job.CronJob {
+	cronJobs := make([]*job.CronJob, 0, len(m.CronJobDb))
+	for _, cj := range m.CronJobDb {
+		cronJobs = append(cronJobs, cj)
+	}
+	return cronJobs
+}
+
+func (m *Manager) SuspendCronJob(id uuid.UUID, suspend bool) (*job.CronJob, error) {
+	cj, ok := m.CronJobDb[id]
+	if !ok {
+		return nil, fmt.Errorf("cron job %s not found", id)
+	}
+	cj.Suspend = suspend
+	return cj, nil
+}
+
+// RemoveCronJob forgets the cron job together with all jobs it created
+func (m *Manager) RemoveCronJob(id uuid.UUID) error {
+	cj, ok := m.CronJobDb[id]
+	if !ok {
+		return fmt.Errorf("cron job %s not found", id)
+	}
+	delete(m.CronJobDb, id)
+	for _, j := range m.cronJobRuns(cj.ID) {
+		_ = m.RemoveJob(j.ID)
+	}
+	log.Printf("[Manager] Removed cron job %s\n", cj.Name)
+	return nil
+}
+
+// jobs created by a cron job, oldest first
+func (m *Manager) cronJobRuns(cronJobID uuid.UUID) []*job.Job {
+	var jobs []*job.Job
+	for _, j := range m.JobDb {
+		if j.CronJobID == cronJobID {
+			jobs = append(jobs, j)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ScheduledTime.Before(jobs[j].ScheduledTime)
+	})
+	return jobs
+}
+
+func (m *Manager) ReconcileCronJobs() {
+	for {
+		log.Println("[Manager] Reconciling cron jobs")
+		m.locked(func() { m.reconcileCronJobs(time.Now()) })
+		log.Println("[Manager] Cron jobs reconciled, sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (m *Manager) reconcileCronJobs(now time.Time) {
+	for _, cj := range m.GetCronJobs() {
+		m.reconcileCronJob(cj, now)
+	}
+}
+
+func (m *Manager) reconcileCronJob(cj *job.CronJob, now time.Time) {
+	var active, succeeded, failed []*job.Job
+	for _, j := range m.cronJobRuns(cj.ID) {
+		switch j.Status.State {
+		case job.Succeeded:
+			succeeded = append(succeeded, j)
+			if j.Status.CompletionTime.After(cj.Status.LastSuccessfulTime) {
+				cj.Status.LastSuccessfulTime = j.Status.CompletionTime
+			}
+		case job.Failed:
+			failed = append(failed, j)
+		default:
+			active = append(active, j)
+		}
+	}
+	m.pruneCronJobHistory(succeeded, *cj.SuccessfulJobsHistoryLimit)
+	m.pruneCronJobHistory(failed, *cj.FailedJobsHistoryLimit)
+
+	if cj.Suspend {
+		cj.Status.Active = jobIDs(active)
+		return
+	}
+	runs, err := cj.DueRuns(now)
+	if err != nil {
+		log.Printf("[Manager] Cron job %s has an invalid schedule: %v\n", cj.Name, err)
+		return
+	}
+	for _, scheduled := range runs {
+		switch cj.ConcurrencyPolicy {
+		case job.ForbidConcurrent:
+			if len(active) > 0 {
+				// try again on the next pass, as long as the starting deadline allows it
+				log.Printf("[Manager] Cron job %s run at %s waits for the previous one\n", cj.Name, scheduled)
+				cj.Status.Active = jobIDs(active)
+				return
+			}
+		case job.ReplaceConcurrent:
+			for _, j := range active {
+				// the replaced job stays around as a failed run, counted by the history limit
+				log.Printf("[Manager] Cron job %s run at %s replaces job %s\n", cj.Name, scheduled, j.Name)
+				m.finishJob(j, job.Failed, job.Replaced, m.activeJobTasks(j.ID))
+			}
+			active = nil
+		}
+
+		created, err := m.AddJob(cj.NewJob(scheduled))
+		if err != nil {
+			log.Printf("[Manager] Error while creating job for cron job %s: %v\n", cj.Name, err)
+			return
+		}
+		active = append(active, created)
+		cj.Status.LastScheduleTime = scheduled
+		log.Printf("[Manager] Cron job %s started job %s for %s\n", cj.Name, created.Name, scheduled)
+	}
+	cj.Status.Active = jobIDs(active)
+}
+
+// removes the oldest finished jobs above the limit
+func (m *Manager) pruneCronJobHistory(finished []*job.Job, limit int) {
+	if len(finished) <= limit {
+		return
+	}
+	for _, j := range finished[:len(finished)-limit] {
+		_ = m.RemoveJob(j.ID)
+	}
+}
+
+func jobIDs(jobs []*job.Job) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(jobs))
+	for _, j := range jobs {
+		ids = append(ids, j.ID)
+	}
+	return ids
+}
@@ -0,0 +1,79 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/group"
+)
+
+func (a *Api) CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	g := group.Group{}
+	if err := d.Decode(&g); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	created, err := a.Manager.AddGroup(g)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid group: %v", err))
+		return
+	}
+
+	log.Printf("Added group %s\n", created.ID)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(created)
+}
+
+func (a *Api) GetGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.GetGroups())
+}
+
+func (a *Api) GetGroupHandler(w http.ResponseWriter, r *http.Request) {
+	g, ok := a.lookupGroup(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(g)
+}
+
+func (a *Api) StopGroupHandler(w http.ResponseWriter, r *http.Request) {
+	g, ok := a.lookupGroup(w, r)
+	if !ok {
+		return
+	}
+	if group.IsFinished(g.State) {
+		writeError(w, 409, fmt.Sprintf("Group %s is already %s", g.ID, g.State))
+		return
+	}
+	if err := a.Manager.StopGroup(g.ID); err != nil {
+		writeError(w, 404, err.Error())
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (a *Api) lookupGroup(w http.ResponseWriter, r *http.Request) (*group.Group, bool) {
+	gID, err := uuid.Parse(chi.URLParam(r, "groupID"))
+	if err != nil {
+		writeError(w, 400, "GroupID passed in the request looks invalid")
+		return nil, false
+	}
+	g, ok := a.Manager.GroupDb[gID]
+	if !ok {
+		writeError(w, 404, fmt.Sprintf("Group %s not found", gID))
+		return nil, false
+	}
+	return g, true
+}
@@ -0,0 +1,202 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/group"
+	"kjarmicki.github.com/cube/task"
+)
+
+// AddGroup registers the containers of the group as pending tasks and places
+// its infra task. Containers follow onto the same worker one by one.
+func (m *Manager) AddGroup(g group.Group) (*group.Group, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	g.CreatedAt = time.Now().UTC()
+	infra := g.Prepare()
+	m.TaskDb[infra.ID] = &infra
+	m.publishTask(WatchCreated, &infra)
+	m.recordTimeline(infra.ID, infra.State, task.TimelineSubmitted, "", fmt.Sprintf("infra of group %s", g.Name))
+	for _, c := range g.All() {
+		t := c.Task
+		m.TaskDb[t.ID] = &t
+		m.publishTask(WatchCreated, &t)
+		m.recordTimeline(t.ID, t.State, task.TimelineSubmitted, "", fmt.Sprintf("container %s of group %s", c.Name, g.Name))
+	}
+	m.GroupDb[g.ID] = &g
+	m.startTask(infra)
+	log.Printf("[Manager] Added group %s (%s) with %d containers\n", g.Name, g.ID, len(g.All()))
+	return &g, nil
+}
+
+func (m *Manager) GetGroups() []*group.Group {
+	groups := make([]*group.Group, 0, len(m.GroupDb))
+	for _, g := range m.GroupDb {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// StopGroup stops the containers of a group in reverse order, the infra task last
+func (m *Manager) StopGroup(id uuid.UUID) error {
+	g, ok := m.GroupDb[id]
+	if !ok {
+		return fmt.Errorf("group %s not found", id)
+	}
+	m.finishGroup(g, group.Stopped, "stopped on request")
+	log.Printf("[Manager] Stopped group %s\n", g.Name)
+	return nil
+}
+
+// groupWorker is where the containers of a group go, which is wherever its infra task runs
+func (m *Manager) groupWorker(t task.Task) (string, string) {
+	w, ok := m.TaskWorkerMap[t.InfraTaskID]
+	if !ok {
+		return "", "waiting for its group to be placed"
+	}
+	if m.isWorkerLost(w) {
+		return "", fmt.Sprintf("node %s running its group is lost", w)
+	}
+	return w, ""
+}
+
+func (m *Manager) ReconcileGroups() {
+	for {
+		log.Println("[Manager] Reconciling groups")
+		m.locked(m.reconcileGroups)
+		log.Println("[Manager] Groups reconciled, sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (m *Manager) reconcileGroups() {
+	for _, g := range m.GetGroups() {
+		if !group.IsFinished(g.State) {
+			m.reconcileGroup(g)
+		}
+	}
+}
+
+// reconcileGroup moves a group through its states, starting at most one
+// container per pass since each waits for the previous one
+func (m *Manager) reconcileGroup(g *group.Group) {
+	infra, ok := m.TaskDb[g.InfraTaskID]
+	if !ok {
+		return
+	}
+	switch infra.State {
+	case task.Pending, task.Scheduled:
+		g.Reason = infra.PendingReason
+		return
+	case task.Running:
+	default:
+		m.finishGroup(g, group.Failed, fmt.Sprintf("infra task %s is %s", infra.ID, infra.State))
+		return
+	}
+
+	if g.State == group.Pending {
+		g.State = group.Initializing
+		g.Reason = ""
+	}
+	if g.State == group.Initializing {
+		for i := range g.InitContainers {
+			c := &g.InitContainers[i]
+			if done, err := m.stepContainer(g, c); err != nil {
+				m.finishGroup(g, group.Failed, err.Error())
+				return
+			} else if !done {
+				return
+			}
+		}
+		g.State = group.Starting
+	}
+
+	for i := range g.Containers {
+		c := &g.Containers[i]
+		if done, err := m.stepContainer(g, c); err != nil {
+			m.finishGroup(g, group.Failed, err.Error())
+			return
+		} else if !done {
+			return
+		}
+	}
+	if g.State == group.Starting {
+		log.Printf("[Manager] Every container of group %s is running\n", g.Name)
+	}
+	g.State = group.Running
+}
+
+// stepContainer starts a waiting container and tells whether it's done with,
+// meaning an init container that succeeded or any other container that runs
+func (m *Manager) stepContainer(g *group.Group, c *group.Container) (bool, error) {
+	t, ok := m.TaskDb[c.Task.ID]
+	if !ok {
+		return false, fmt.Errorf("task of container %s is gone", c.Name)
+	}
+	switch c.State {
+	case group.Waiting:
+		log.Printf("[Manager] Starting container %s of group %s\n", c.Name, g.Name)
+		c.State = group.Started
+		m.startTask(*t)
+		return false, nil
+	case group.Succeeded:
+		return true, nil
+	}
+
+	switch {
+	case t.State == task.Completed && t.Init && t.ExitCode == 0:
+		c.State = group.Succeeded
+		return true, nil
+	case t.State == task.Completed || t.State == task.Failed:
+		c.State = group.Failed
+		c.Reason = fmt.Sprintf("task %s exited with code %d", t.ID, t.ExitCode)
+		if t.FailureReason != "" {
+			c.Reason = fmt.Sprintf("%s: %s", t.FailureReason, t.FailureMessage)
+		}
+		return false, fmt.Errorf("container %s failed: %s", c.Name, c.Reason)
+	case t.State == task.Running && !t.Init:
+		return true, nil
+	}
+	return false, nil
+}
+
+// finishGroup stops whatever still runs, last started first, and skips the containers that never started
+func (m *Manager) finishGroup(g *group.Group, state string, reason string) {
+	all := g.All()
+	for i := len(all) - 1; i >= 0; i-- {
+		c := all[i]
+		t, ok := m.TaskDb[c.Task.ID]
+		if !ok {
+			continue
+		}
+		switch {
+		case c.State == group.Waiting:
+			c.State = group.Skipped
+			c.Reason = reason
+			t.State = task.Skipped
+			t.FinishTime = time.Now().UTC()
+			m.recordTimeline(t.ID, t.State, task.TimelineKindForState(t.State), "", reason)
+			m.publishTask(WatchUpdated, t)
+		case m.isTaskLive(t):
+			m.stopTask(t, fmt.Sprintf("group %s %s", g.Name, state))
+		}
+	}
+	if infra, ok := m.TaskDb[g.InfraTaskID]; ok && m.isTaskLive(infra) {
+		m.stopTask(infra, fmt.Sprintf("group %s %s", g.Name, state))
+	}
+	g.State = state
+	g.Reason = reason
+	g.Finished = time.Now().UTC()
+	log.Printf("[Manager] Group %s has %s: %s\n", g.Name, state, reason)
+}
@@ -1,10 +1,13 @@
 package manager
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -25,15 +28,115 @@ type ErrResponse struct {
 
 func (a *Api) initRouter() {
 	a.Router = chi.NewRouter()
-	a.Router.Route("/tasks", func(r chi.Router) {
+	// long-lived requests only hold the lock while they look something up
+	a.Router.Get("/tasks/watch", a.WatchTasksHandler)
+	a.Router.Get("/tasks/{taskID}/logs", a.GetTaskLogsHandler)
+	a.Router.Post("/tasks/{taskID}/exec", a.ExecTaskHandler)
+	a.Router.Post("/images/pull", a.PrePullImageHandler)
+	// so do those calling a worker
+	a.Router.Post("/volumes", a.CreateVolumeHandler)
+	a.Router.Delete("/volumes/{name}", a.DeleteVolumeHandler)
+	a.Router.Group(a.lockedRoutes)
+}
+
+func (a *Api) lockedRoutes(router chi.Router) {
+	router.Use(a.locked)
+	router.Route("/tasks", func(r chi.Router) {
 		r.Post("/", a.StartTaskHandler)
 		r.Get("/", a.GetTasksHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
+			r.Get("/", a.GetTaskHandler)
 			r.Delete("/", a.StopTaskHandler)
+			r.Get("/events", a.GetTaskEventsHandler)
+		})
+	})
+	router.Route("/services", func(r chi.Router) {
+		r.Post("/", a.CreateServiceHandler)
+		r.Get("/", a.GetServicesHandler)
+		r.Route("/{serviceID}", func(r chi.Router) {
+			r.Get("/", a.GetServiceHandler)
+			r.Put("/", a.ScaleServiceHandler)
+			r.Delete("/", a.DeleteServiceHandler)
+			r.Put("/template", a.UpdateServiceHandler)
+			r.Get("/revisions", a.GetRevisionsHandler)
+			r.Post("/rollback", a.RollbackServiceHandler)
+			r.Post("/resume", a.ResumeServiceHandler)
+		})
+	})
+	router.Route("/jobs", func(r chi.Router) {
+		r.Post("/", a.CreateJobHandler)
+		r.Get("/", a.GetJobsHandler)
+		r.Route("/{jobID}", func(r chi.Router) {
+			r.Get("/", a.GetJobHandler)
+			r.Delete("/", a.DeleteJobHandler)
+		})
+	})
+	router.Route("/cronjobs", func(r chi.Router) {
+		r.Post("/", a.CreateCronJobHandler)
+		r.Get("/", a.GetCronJobsHandler)
+		r.Route("/{cronJobID}", func(r chi.Router) {
+			r.Get("/", a.GetCronJobHandler)
+			r.Delete("/", a.DeleteCronJobHandler)
+			r.Post("/suspend", a.SuspendCronJobHandler)
+			r.Post("/resume", a.ResumeCronJobHandler)
+		})
+	})
+	router.Post("/apply", a.ApplyHandler)
+	router.Get("/nodes", a.GetNodesHandler)
+	router.Route("/secrets", func(r chi.Router) {
+		r.Post("/", a.CreateSecretHandler)
+		r.Get("/", a.GetSecretsHandler)
+		r.Route("/{name}", func(r chi.Router) {
+			r.Get("/", a.GetSecretHandler)
+			r.Put("/", a.UpdateSecretHandler)
+			r.Delete("/", a.DeleteSecretHandler)
+		})
+	})
+	router.Route("/configs", func(r chi.Router) {
+		r.Post("/", a.CreateConfigHandler)
+		r.Get("/", a.GetConfigsHandler)
+		r.Route("/{name}", func(r chi.Router) {
+			r.Get("/", a.GetConfigHandler)
+			r.Put("/", a.UpdateConfigHandler)
+			r.Delete("/", a.DeleteConfigHandler)
+			r.Get("/versions", a.GetConfigVersionsHandler)
+		})
+	})
+	router.Route("/registries", func(r chi.Router) {
+		r.Post("/", a.CreateRegistryHandler)
+		r.Get("/", a.GetRegistriesHandler)
+		r.Delete("/{server}", a.DeleteRegistryHandler)
+	})
+	router.Get("/volumes", a.GetVolumesHandler)
+	router.Get("/volumes/{name}", a.GetVolumeHandler)
+	router.Route("/workflows", func(r chi.Router) {
+		r.Post("/", a.CreateWorkflowHandler)
+		r.Get("/", a.GetWorkflowsHandler)
+		r.Route("/{workflowID}", func(r chi.Router) {
+			r.Get("/", a.GetWorkflowHandler)
+			r.Delete("/", a.CancelWorkflowHandler)
+			r.Get("/graph", a.GetWorkflowGraphHandler)
+		})
+	})
+	router.Route("/groups", func(r chi.Router) {
+		r.Post("/", a.CreateGroupHandler)
+		r.Get("/", a.GetGroupsHandler)
+		r.Route("/{groupID}", func(r chi.Router) {
+			r.Get("/", a.GetGroupHandler)
+			r.Delete("/", a.StopGroupHandler)
 		})
 	})
 }
 
+// locked serializes requests with each other and with the background passes of the manager
+func (a *Api) locked(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Manager.mu.Lock()
+		defer a.Manager.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (a *Api) Start() {
 	a.initRouter()
 	_ = http.ListenAndServe(fmt.Sprintf("%s:%d", a.Address, a.Port), a.Router)
@@ -55,6 +158,11 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := te.Task.Validate(); err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid task: %v", err))
+		return
+	}
+
 	a.Manager.AddTask(te)
 	log.Printf("Added task %s\n", te.Task.ID)
 	w.WriteHeader(201)
@@ -62,9 +170,48 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *Api) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseTaskQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid task query: %v", err))
+		return
+	}
+	page, err := a.Manager.QueryTasks(q)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid task query: %v", err))
+		return
+	}
+
 	w.Header().Set("content-type", "application/json")
+	w.Header().Set("X-Resource-Version", strconv.FormatUint(a.Manager.ResourceVersion(), 10))
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
 	w.WriteHeader(200)
-	_ = json.NewEncoder(w).Encode(a.Manager.GetTasks())
+	_ = json.NewEncoder(w).Encode(page.Tasks)
+}
+
+func (a *Api) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, 400, "TaskID passed in the request looks invalid")
+		return
+	}
+	t, ok := a.Manager.GetTask(tID)
+	if !ok {
+		writeError(w, 404, fmt.Sprintf("Task %s not found", tID))
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(t)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	log.Println(msg)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ErrResponse{Message: msg})
 }
 
 func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
@@ -88,17 +235,217 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	te := task.TaskEvent{
-		ID:        uuid.New(),
-		State:     task.Completed,
-		Timestamp: time.Now(),
+	a.Manager.stopTask(taskToStop, "requested through the API")
+	w.WriteHeader(204)
+}
+
+func (a *Api) GetTaskEventsHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		log.Printf("TaskID passed in the request looks invalid\n")
+		w.WriteHeader(400)
+		return
+	}
+	events, ok := a.Manager.GetTimeline(tID)
+	if !ok {
+		log.Printf("No events found for task %s\n", tID)
+		w.WriteHeader(404)
+		return
 	}
 
-	taskCopy := *taskToStop
-	taskCopy.State = task.Completed
-	te.Task = taskCopy
-	a.Manager.AddTask(te)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(events)
+}
 
-	log.Printf("Added task event %s to stop task %s", te.ID, taskToStop.ID)
-	w.WriteHeader(204)
+// WatchTasksHandler streams task changes as server-sent events. Clients resume
+// with ?resourceVersion=N (or the standard Last-Event-ID header) and get every
+// change newer than N, or 410 when N has already fallen out of history.
+func (a *Api) WatchTasksHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, 500, "Streaming is not supported by the connection")
+		return
+	}
+
+	since := r.URL.Query().Get("resourceVersion")
+	if since == "" {
+		since = r.Header.Get("Last-Event-ID")
+	}
+	var version uint64
+	if since != "" {
+		v, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			writeError(w, 400, fmt.Sprintf("Invalid resource version %q", since))
+			return
+		}
+		version = v
+	}
+
+	backlog, events, err := a.Manager.Watch(version)
+	if err != nil {
+		writeError(w, 410, err.Error())
+		return
+	}
+	defer a.Manager.StopWatching(events)
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	for _, e := range backlog {
+		if writeWatchEvent(w, e) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				log.Printf("Watcher fell behind, closing the stream\n")
+				return
+			}
+			if writeWatchEvent(w, e) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeWatchEvent(w http.ResponseWriter, e WatchEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Error while marshaling watch event %d: %v\n", e.ResourceVersion, err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ResourceVersion, e.Type, data)
+	return err
+}
+
+// GetTaskLogsHandler proxies the logs request to the worker running the task,
+// passing the query (tail, since, follow...) through untouched
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, 400, "TaskID passed in the request looks invalid")
+		return
+	}
+	worker, ok := a.Manager.taskWorker(tID)
+	if !ok {
+		writeError(w, 404, fmt.Sprintf("Task %s is not placed on any worker", tID))
+		return
+	}
+
+	url := fmt.Sprintf("http://%s/tasks/%s/logs?%s", worker, tID, r.URL.RawQuery)
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		writeError(w, 500, fmt.Sprintf("Error while preparing logs request: %v", err))
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		writeError(w, 502, fmt.Sprintf("Error while connecting to worker %s: %v", worker, err))
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("content-type", resp.Header.Get("content-type"))
+	w.WriteHeader(resp.StatusCode)
+	copyFlushing(w, resp.Body)
+}
+
+// copies a streamed response as it arrives instead of buffering it
+func copyFlushing(w http.ResponseWriter, r io.Reader) {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ExecTaskHandler relays an exec session between the client and the worker
+// running the task; frames are passed through in both directions untouched
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, 400, "TaskID passed in the request looks invalid")
+		return
+	}
+	worker, ok := a.Manager.taskWorker(tID)
+	if !ok {
+		writeError(w, 404, fmt.Sprintf("Task %s is not placed on any worker", tID))
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error reading body: %v", err))
+		return
+	}
+
+	url := fmt.Sprintf("http://%s/tasks/%s/exec", worker, tID)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		writeError(w, 500, fmt.Sprintf("Error while preparing exec request: %v", err))
+		return
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", task.ExecUpgrade)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		writeError(w, 502, fmt.Sprintf("Error while connecting to worker %s: %v", worker, err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		w.Header().Set("content-type", resp.Header.Get("content-type"))
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+		return
+	}
+	upstream := resp.Body.(io.ReadWriteCloser)
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, 500, fmt.Sprintf("Error while taking over the connection: %v", err))
+		return
+	}
+	defer conn.Close()
+	_, _ = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", task.ExecUpgrade)
+	_ = rw.Flush()
+
+	go func() {
+		_, _ = io.Copy(upstream, rw.Reader)
+	}()
+	// the worker closes its side once the exit frame is out
+	_, _ = io.Copy(conn, upstream)
+}
+
+func (a *Api) GetNodesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.GetNodes())
 }
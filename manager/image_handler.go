@@ -0,0 +1,25 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func (a *Api) PrePullImageHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := PullRequest{}
+	if err := d.Decode(&req); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	results, err := a.Manager.PrePullImage(req)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,101 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"kjarmicki.github.com/cube/task"
+	"kjarmicki.github.com/cube/worker"
+)
+
+// PullRequest asks for an image to be pulled ahead of time on the given nodes, or on all of them
+type PullRequest struct {
+	Image string
+	Nodes []string
+}
+
+// PullResult tells how pulling went on a single node
+type PullResult struct {
+	Node  string
+	Image *task.Image `json:",omitempty"`
+	Error string      `json:",omitempty"`
+}
+
+// PrePullImage has workers pull an image before tasks using it get scheduled there.
+// Pulls take long, so it takes the manager's lock only around its bookkeeping.
+func (m *Manager) PrePullImage(req PullRequest) ([]PullResult, error) {
+	if req.Image == "" {
+		return nil, fmt.Errorf("image is required")
+	}
+	nodes := req.Nodes
+	if len(nodes) == 0 {
+		nodes = m.Workers
+	}
+	for _, n := range nodes {
+		if !slices.Contains(m.Workers, n) {
+			return nil, fmt.Errorf("unknown node %s", n)
+		}
+	}
+	auth, err := m.registryAuth(req.Image)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(worker.PullRequest{Image: req.Image, RegistryAuth: auth})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]PullResult, 0, len(nodes))
+	for _, n := range nodes {
+		result := PullResult{Node: n}
+		var lost bool
+		m.locked(func() { lost = m.isWorkerLost(n) })
+		if lost {
+			result.Error = fmt.Sprintf("node %s is lost", n)
+			results = append(results, result)
+			continue
+		}
+		img, err := m.pullOn(n, data)
+		if err != nil {
+			log.Printf("[Manager] Error pulling image %s on %s: %v\n", req.Image, n, err)
+			result.Error = err.Error()
+		} else {
+			log.Printf("[Manager] Pulled image %s on %s\n", req.Image, n)
+			result.Image = &img
+			m.locked(func() { m.rememberImage(n, img) })
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (m *Manager) pullOn(w string, data []byte) (task.Image, error) {
+	resp, err := http.Post(fmt.Sprintf("http://%s/images", w), "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return task.Image{}, fmt.Errorf("error connecting to %s: %w", w, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return task.Image{}, workerError(resp)
+	}
+	img := task.Image{}
+	err = json.NewDecoder(resp.Body).Decode(&img)
+	return img, err
+}
+
+// the node reports the image with its next stats, until then placement learns about it here
+func (m *Manager) rememberImage(w string, img task.Image) {
+	n := m.workerNode(w)
+	if n == nil {
+		return
+	}
+	for _, name := range img.Names {
+		if !slices.Contains(n.Images, name) {
+			n.Images = append(n.Images, name)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/job"
+)
+
+func (a *Api) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	j := job.Job{}
+	if err := d.Decode(&j); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	created, err := a.Manager.AddJob(j)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid job: %v", err))
+		return
+	}
+
+	log.Printf("Added job %s\n", created.ID)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(created)
+}
+
+func (a *Api) GetJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.GetJobs())
+}
+
+func (a *Api) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := a.lookupJob(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(j)
+}
+
+func (a *Api) DeleteJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := a.lookupJob(w, r)
+	if !ok {
+		return
+	}
+	if err := a.Manager.RemoveJob(j.ID); err != nil {
+		writeError(w, 404, err.Error())
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (a *Api) lookupJob(w http.ResponseWriter, r *http.Request) (*job.Job, bool) {
+	jID, err := uuid.Parse(chi.URLParam(r, "jobID"))
+	if err != nil {
+		writeError(w, 400, "JobID passed in the request looks invalid")
+		return nil, false
+	}
+	j, ok := a.Manager.JobDb[jID]
+	if !ok {
+		writeError(w, 404, fmt.Sprintf("Job %s not found", jID))
+		return nil, false
+	}
+	return j, true
+}
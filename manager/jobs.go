@@ -0,0 +1,151 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/job"
+	"kjarmicki.github.com/cube/task"
+)
+
+func (m *Manager) AddJob(j job.Job) (*job.Job, error) {
+	if err := j.Validate(); err != nil {
+		return nil, err
+	}
+	j.SetDefaults()
+	if j.ID == uuid.Nil {
+		j.ID = uuid.New()
+	}
+	j.CreatedAt = time.Now().UTC()
+	j.Status = job.Status{
+		State:     job.Active,
+		StartTime: j.CreatedAt,
+	}
+	m.JobDb[j.ID] = &j
+	log.Printf("[Manager] Added job %s (%s) for %d completions\n", j.Name, j.ID, j.Completions)
+	return &j, nil
+}
+
+func (m *Manager) GetJobs() []*job.Job {
+	jobs := make([]*job.Job, 0, len(m.JobDb))
+	for _, j := range m.JobDb {
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+// RemoveJob forgets the job and stops whatever it still has running
+func (m *Manager) RemoveJob(id uuid.UUID) error {
+	j, ok := m.JobDb[id]
+	if !ok {
+		return fmt.Errorf("job %s not found", id)
+	}
+	delete(m.JobDb, id)
+	for _, t := range m.activeJobTasks(j.ID) {
+		m.stopTask(t, fmt.Sprintf("job %s removed", j.Name))
+	}
+	log.Printf("[Manager] Removed job %s\n", j.Name)
+	return nil
+}
+
+func (m *Manager) jobTasks(jobID uuid.UUID) []*task.Task {
+	var tasks []*task.Task
+	for _, t := range m.TaskDb {
+		if t.JobID == jobID {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks
+}
+
+func (m *Manager) activeJobTasks(jobID uuid.UUID) []*task.Task {
+	var active []*task.Task
+	for _, t := range m.jobTasks(jobID) {
+		if m.isJobTaskActive(t) {
+			active = append(active, t)
+		}
+	}
+	return active
+}
+
+func (m *Manager) isJobTaskActive(t *task.Task) bool {
+	if m.stopping[t.ID] {
+		return false
+	}
+	switch t.State {
+	case task.Pending, task.Scheduled, task.Running:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Manager) ReconcileJobs() {
+	for {
+		log.Println("[Manager] Reconciling jobs")
+		m.locked(m.reconcileJobs)
+		log.Println("[Manager] Jobs reconciled, sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (m *Manager) reconcileJobs() {
+	for _, j := range m.GetJobs() {
+		if !j.IsFinished() {
+			m.reconcileJob(j)
+		}
+	}
+}
+
+// counts the runs of a job, decides whether it's done and starts
+// new runs up to the parallelism limit when it isn't
+func (m *Manager) reconcileJob(j *job.Job) {
+	var active []*task.Task
+	succeeded, failed := 0, 0
+	for _, t := range m.jobTasks(j.ID) {
+		switch {
+		case m.isJobTaskActive(t):
+			active = append(active, t)
+		case t.State == task.Completed && t.ExitCode == 0:
+			succeeded++
+		case t.State == task.Completed || t.State == task.Failed:
+			failed++
+		}
+	}
+	j.Status.Active = len(active)
+	j.Status.Succeeded = succeeded
+	j.Status.Failed = failed
+
+	now := time.Now().UTC()
+	switch {
+	case succeeded >= j.Completions:
+		m.finishJob(j, job.Succeeded, "", active)
+		return
+	case failed > j.BackoffLimit:
+		m.finishJob(j, job.Failed, job.BackoffLimitExceeded, active)
+		return
+	case j.DeadlineExceeded(now):
+		m.finishJob(j, job.Failed, job.DeadlineExceeded, active)
+		return
+	}
+
+	toStart := min(j.Parallelism, j.Completions-succeeded) - len(active)
+	for i := 0; i < toStart; i++ {
+		t := j.NewTask()
+		log.Printf("[Manager] Starting task %s for job %s\n", t.ID, j.Name)
+		m.startTask(t)
+		j.Status.Active++
+	}
+}
+
+func (m *Manager) finishJob(j *job.Job, state string, reason string, active []*task.Task) {
+	j.Status.State = state
+	j.Status.Reason = reason
+	j.Status.CompletionTime = time.Now().UTC()
+	for _, t := range active {
+		m.stopTask(t, fmt.Sprintf("job %s has %s", j.Name, state))
+	}
+	log.Printf("[Manager] Job %s has %s %s\n", j.Name, state, reason)
+}
@@ -5,32 +5,66 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/go-connections/nat"
-	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/config"
+	"kjarmicki.github.com/cube/group"
+	"kjarmicki.github.com/cube/job"
 	"kjarmicki.github.com/cube/node"
 	"kjarmicki.github.com/cube/scheduler"
+	"kjarmicki.github.com/cube/secret"
+	"kjarmicki.github.com/cube/service"
 	"kjarmicki.github.com/cube/task"
+	"kjarmicki.github.com/cube/volume"
 	"kjarmicki.github.com/cube/worker"
+	"kjarmicki.github.com/cube/workflow"
 )
 
 type Manager struct {
-	Pending       queue.Queue // tasks before submission
-	TaskDb        map[uuid.UUID]*task.Task
-	EventDb       map[uuid.UUID]*task.TaskEvent
-	Workers       []string               // endpoints, as in <hostname>:<port>
-	WorkerTaskMap map[string][]uuid.UUID // list of tasks by worker
-	TaskWorkerMap map[uuid.UUID]string   // worker by task
-	LastWorker    int
-	WorkerNodes   []*node.Node
-	Scheduler     scheduler.Scheduler
+	// guards everything below but the timelines and the watch hub, which have
+	// their own locks. Every API request and background pass holds it.
+	mu             sync.Mutex
+	Pending        PendingQueue // tasks before submission
+	TaskDb         map[uuid.UUID]*task.Task
+	EventDb        map[uuid.UUID]*task.TaskEvent
+	Workers        []string               // endpoints, as in <hostname>:<port>
+	WorkerTaskMap  map[string][]uuid.UUID // list of tasks by worker
+	TaskWorkerMap  map[uuid.UUID]string   // worker by task
+	LastWorker     int
+	WorkerNodes    []*node.Node
+	Scheduler      scheduler.Scheduler
+	Timelines      map[uuid.UUID][]task.TimelineEvent // append-only history by task
+	timelinesMu    sync.Mutex
+	watch          *watchHub
+	ServiceDb      map[uuid.UUID]*service.Service
+	Revisions      map[uuid.UUID][]service.Revision // template history by service
+	JobDb          map[uuid.UUID]*job.Job
+	CronJobDb      map[uuid.UUID]*job.CronJob
+	WorkflowDb     map[uuid.UUID]*workflow.Workflow
+	GroupDb        map[uuid.UUID]*group.Group
+	stopping       map[uuid.UUID]bool // tasks asked to stop that haven't finished yet
+	healthy        map[uuid.UUID]bool // how the last health check of running tasks went
+	workerFails    map[string]int     // consecutive failed polls by worker
+	Preemption     bool               // lets tasks evict lower priority ones when no node has room for them
+	VolumeDb       map[string]*volume.Volume
+	Secrets        *secret.Store // nil unless a key was configured
+	Registries     *secret.Store // registry credentials, apart from secrets so tasks can't claim them
+	ConfigDb       map[string]*config.Config
+	ConfigVersions map[string][]config.Config // history by config
+	replacing      uuid.UUID                  // replacement reconcileConfigs waits on before the next one
 }
 
+// number of consecutive failed polls after which a worker and its tasks are considered lost
+const workerLostAfter = 3
+
 func New(workers []string, schedulerType string) *Manager {
 	taskDb := make(map[uuid.UUID]*task.Task)
 	eventDb := make(map[uuid.UUID]*task.TaskEvent)
@@ -51,21 +85,91 @@ func New(workers []string, schedulerType string) *Manager {
 	}
 
 	return &Manager{
-		Pending:       *queue.New(),
-		Workers:       workers,
-		TaskDb:        taskDb,
-		EventDb:       eventDb,
-		WorkerTaskMap: workerTaskMap,
-		TaskWorkerMap: taskWorkerMap,
-		WorkerNodes:   nodes,
-		Scheduler:     s,
+		Pending:        PendingQueue{},
+		Workers:        workers,
+		TaskDb:         taskDb,
+		EventDb:        eventDb,
+		WorkerTaskMap:  workerTaskMap,
+		TaskWorkerMap:  taskWorkerMap,
+		WorkerNodes:    nodes,
+		Scheduler:      s,
+		Timelines:      make(map[uuid.UUID][]task.TimelineEvent),
+		watch:          newWatchHub(),
+		ServiceDb:      make(map[uuid.UUID]*service.Service),
+		Revisions:      make(map[uuid.UUID][]service.Revision),
+		JobDb:          make(map[uuid.UUID]*job.Job),
+		CronJobDb:      make(map[uuid.UUID]*job.CronJob),
+		WorkflowDb:     make(map[uuid.UUID]*workflow.Workflow),
+		GroupDb:        make(map[uuid.UUID]*group.Group),
+		stopping:       make(map[uuid.UUID]bool),
+		healthy:        make(map[uuid.UUID]bool),
+		workerFails:    make(map[string]int),
+		VolumeDb:       make(map[string]*volume.Volume),
+		ConfigDb:       make(map[string]*config.Config),
+		ConfigVersions: make(map[string][]config.Config),
 	}
 }
 
+// workerClient calls workers and the health checks of tasks, those that don't
+// answer in time count as unreachable. Calls are made without holding the lock.
+var workerClient = &http.Client{Timeout: 10 * time.Second}
+
+// locked runs f holding the manager's lock
+func (m *Manager) locked(f func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f()
+}
+
+// taskWorker finds the worker a task is placed on, for requests that don't hold the lock
+func (m *Manager) taskWorker(id uuid.UUID) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.TaskWorkerMap[id]
+	return w, ok
+}
+
 func (m *Manager) AddTask(te task.TaskEvent) {
+	if te.State == task.Completed {
+		m.stopping[te.Task.ID] = true
+		m.recordTimeline(te.Task.ID, te.Task.State, task.TimelineStopRequested, m.TaskWorkerMap[te.Task.ID], "")
+	} else {
+		if _, known := m.TaskDb[te.Task.ID]; !known {
+			// visible right away, even before it's sent to a worker
+			t := te.Task
+			t.State = task.Pending
+			m.TaskDb[t.ID] = &t
+			m.publishTask(WatchCreated, &t)
+		}
+		m.recordTimeline(te.Task.ID, task.Pending, task.TimelineSubmitted, "", "")
+	}
 	m.Pending.Enqueue(te)
 }
 
+func (m *Manager) recordTimeline(taskID uuid.UUID, state task.State, kind string, node string, reason string) {
+	m.timelinesMu.Lock()
+	defer m.timelinesMu.Unlock()
+	m.Timelines[taskID] = append(m.Timelines[taskID], task.TimelineEvent{
+		TaskID:    taskID,
+		Timestamp: time.Now().UTC(),
+		Kind:      kind,
+		State:     state,
+		Node:      node,
+		Reason:    reason,
+	})
+}
+
+func (m *Manager) GetTimeline(taskID uuid.UUID) ([]task.TimelineEvent, bool) {
+	m.timelinesMu.Lock()
+	defer m.timelinesMu.Unlock()
+	events, ok := m.Timelines[taskID]
+	if !ok {
+		return nil, false
+	}
+	// hand out a copy so callers can't rewrite history
+	return append([]task.TimelineEvent(nil), events...), true
+}
+
 func (m *Manager) GetTasks() []*task.Task {
 	tasks := make([]*task.Task, 0, len(m.TaskDb))
 	for _, task := range m.TaskDb {
@@ -76,48 +180,128 @@ func (m *Manager) GetTasks() []*task.Task {
 
 func (m *Manager) SelectWorker() string {
 	var newWorker int
-	if m.LastWorker+1 < len(m.Workers) {
-		newWorker = m.LastWorker + 1
-	} else {
-		newWorker = 0
+	// lost workers are skipped, unless every one of them is lost
+	for range m.Workers {
+		if m.LastWorker+1 < len(m.Workers) {
+			newWorker = m.LastWorker + 1
+		} else {
+			newWorker = 0
+		}
+		m.LastWorker = newWorker
+		if !m.isWorkerLost(m.Workers[newWorker]) {
+			break
+		}
 	}
-	m.LastWorker = newWorker
 	return m.Workers[newWorker]
 }
 
+func (m *Manager) isWorkerLost(worker string) bool {
+	return m.workerFails[worker] >= workerLostAfter
+}
+
+// marks every task that was supposed to run on a lost worker as failed,
+// so that the owners of those tasks can replace them elsewhere
+func (m *Manager) workerLost(worker string) {
+	log.Printf("[Manager] Worker %s is lost, failing its tasks\n", worker)
+	for _, id := range m.WorkerTaskMap[worker] {
+		t, ok := m.TaskDb[id]
+		if !ok || t.State == task.Completed || t.State == task.Failed {
+			continue
+		}
+		t.State = task.Failed
+		t.FinishTime = time.Now().UTC()
+		delete(m.stopping, id)
+		m.recordTimeline(id, t.State, task.TimelineFailed, worker, fmt.Sprintf("node %s lost", worker))
+		m.publishTask(WatchUpdated, t)
+	}
+}
+
+func (m *Manager) workerReached(worker string) {
+	if m.isWorkerLost(worker) {
+		log.Printf("[Manager] Worker %s is reachable again\n", worker)
+	}
+	m.workerFails[worker] = 0
+}
+
+func (m *Manager) workerUnreachable(worker string) {
+	m.workerFails[worker]++
+	if m.workerFails[worker] == workerLostAfter {
+		m.workerLost(worker)
+	}
+}
+
+// updateTasks asks the workers without holding the lock, so that a slow one
+// doesn't hold up the manager, and takes it to apply what they report
 func (m *Manager) updateTasks() {
 	for _, worker := range m.Workers {
 		log.Printf("[Manager] Checking worker %s for task updates\n", worker)
-		url := fmt.Sprintf("http://%s/tasks", worker)
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Printf("[Manager] Error while connecting to %s for task updates\n", worker)
+		tasks, reached := fetchWorkerTasks(worker)
+		m.locked(func() {
+			if !reached {
+				m.workerUnreachable(worker)
+				return
+			}
+			m.workerReached(worker)
+			m.applyTaskUpdates(worker, tasks)
+		})
+	}
+}
+
+// fetchWorkerTasks tells whether the worker could be reached and, if it
+// answered properly, what tasks it has
+func fetchWorkerTasks(worker string) ([]*task.Task, bool) {
+	url := fmt.Sprintf("http://%s/tasks", worker)
+	resp, err := workerClient.Get(url)
+	if err != nil {
+		log.Printf("[Manager] Error while connecting to %s for task updates\n", worker)
+		return nil, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[Manager] Unexpected status code from %s (%d)\n", worker, resp.StatusCode)
+		return nil, true
+	}
+	d := json.NewDecoder(resp.Body)
+	var tasks []*task.Task
+	err = d.Decode(&tasks)
+	if err != nil {
+		log.Printf("[Manager] Error while decoding response: %v\n", err)
+		return nil, true
+	}
+	return tasks, true
+}
+
+func (m *Manager) applyTaskUpdates(worker string, tasks []*task.Task) {
+	for _, t := range tasks {
+		log.Printf("[Manager] Attempting to update task %s\n", t.ID)
+		_, ok := m.TaskDb[t.ID]
+		if !ok {
+			log.Printf("[Manager] Task with ID %s not found\n", t.ID)
 			continue
 		}
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("[Manager] Unexpected status code from %s (%d)\n", worker, resp.StatusCode)
-			continue
+		if m.TaskDb[t.ID].State != t.State {
+			reason := "reported by worker"
+			if t.FailureReason != "" {
+				reason = fmt.Sprintf("%s: %s", t.FailureReason, t.FailureMessage)
+			}
+			m.recordTimeline(t.ID, t.State, task.TimelineKindForState(t.State), worker, reason)
 		}
-		d := json.NewDecoder(resp.Body)
-		var tasks []*task.Task
-		err = d.Decode(&tasks)
-		if err != nil {
-			log.Printf("[Manager] Error while decoding response: %v\n", err)
-			continue
+		before := *m.TaskDb[t.ID]
+		m.TaskDb[t.ID].State = t.State
+		m.TaskDb[t.ID].StartTime = t.StartTime
+		m.TaskDb[t.ID].FinishTime = t.FinishTime
+		m.TaskDb[t.ID].ExitCode = t.ExitCode
+		m.TaskDb[t.ID].FailureReason = t.FailureReason
+		m.TaskDb[t.ID].FailureMessage = t.FailureMessage
+		m.TaskDb[t.ID].Substatus = t.Substatus
+		m.TaskDb[t.ID].PullProgress = t.PullProgress
+		m.TaskDb[t.ID].ContainerID = t.ContainerID
+		m.TaskDb[t.ID].HostPorts = t.HostPorts
+		if t.State == task.Completed || t.State == task.Failed {
+			delete(m.stopping, t.ID)
 		}
-
-		for _, t := range tasks {
-			log.Printf("[Manager] Attempting to update task %s\n", t.ID)
-			_, ok := m.TaskDb[t.ID]
-			if !ok {
-				log.Printf("[Manager] Task with ID %s not found\n", t.ID)
-				continue
-			}
-			m.TaskDb[t.ID].State = t.State
-			m.TaskDb[t.ID].StartTime = t.StartTime
-			m.TaskDb[t.ID].FinishTime = t.FinishTime
-			m.TaskDb[t.ID].ContainerID = t.ContainerID
-			m.TaskDb[t.ID].HostPorts = t.HostPorts
+		if !reflect.DeepEqual(before, *m.TaskDb[t.ID]) {
+			m.publishTask(WatchUpdated, m.TaskDb[t.ID])
 		}
 	}
 }
@@ -140,58 +324,146 @@ func (m *Manager) ProcessTasks() {
 	}
 }
 
+// SendWork sends the next task event that can be placed to its worker. The
+// worker is called without holding the lock.
 func (m *Manager) SendWork() {
-	if m.Pending.Len() > 0 {
-		w := m.SelectWorker()
+	var d *dispatch
+	m.locked(func() { d = m.nextDispatch() })
+	if d == nil {
+		return
+	}
+	reply := d.deliver()
+	m.locked(func() { m.dispatched(d, reply) })
+}
 
+// nextDispatch takes the first event off the pending queue that can be placed
+// and marks its task as scheduled
+func (m *Manager) nextDispatch() *dispatch {
+	if m.Pending.Len() == 0 {
+		log.Println("[Manager] No tasks in the queue")
+		return nil
+	}
+	// tasks that can't be placed yet are retried on the next pass
+	defer m.Pending.Unpark()
+	for m.Pending.Len() > 0 {
 		// pull a task off the pending queue
-		e := m.Pending.Dequeue()
-		te := e.(task.TaskEvent)
+		te := m.Pending.Dequeue()
 		t := te.Task
 
+		if _, placed := m.TaskWorkerMap[t.ID]; !placed {
+			if te.State == task.Completed {
+				// no worker ever got it, so there's only the record to finish
+				m.finishUnplaced(t.ID)
+				continue
+			}
+			if known, ok := m.TaskDb[t.ID]; ok && known.State == task.Completed {
+				// stopped while it was still pending
+				continue
+			}
+		}
+
+		payload := te
+		if te.State != task.Completed {
+			var err error
+			if payload, err = m.withSecrets(te); err != nil {
+				m.recordPending(t, err.Error())
+				m.Pending.Park(te)
+				continue
+			}
+			t = payload.Task
+			te.Task = t
+		}
+
+		// tasks already placed on a worker (restarts, stops) go back to it
+		w, placed := m.TaskWorkerMap[t.ID]
+		if !placed {
+			if w, placed = m.placeTask(t); !placed {
+				m.Pending.Park(te)
+				continue
+			}
+			m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], te.Task.ID)
+			m.TaskWorkerMap[t.ID] = w
+		}
 		m.EventDb[te.ID] = &te
-		m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], te.Task.ID)
-		m.TaskWorkerMap[t.ID] = w
 
-		// mark the task as scheduled
-		t.State = task.Scheduled
-		m.TaskDb[t.ID] = &t
+		if te.State != task.Completed {
+			// mark the task as scheduled
+			_, known := m.TaskDb[t.ID]
+			t.State = task.Scheduled
+			t.PendingReason = ""
+			m.TaskDb[t.ID] = &t
+			if known {
+				m.publishTask(WatchUpdated, &t)
+			} else {
+				m.publishTask(WatchCreated, &t)
+			}
+		}
 
-		data, err := json.Marshal(te)
+		data, err := json.Marshal(payload)
 		if err != nil {
 			log.Printf("[Manager] Error while marshaling task %s: %v\n", te.Task.ID, err)
-			return
+			return nil
 		}
+		return &dispatch{te: te, t: t, w: w, data: data}
+	}
+	return nil
+}
 
-		url := fmt.Sprintf("http://%s/tasks", w)
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
-		if err != nil {
-			log.Printf("[Manager] Error while connecting to %s: %v\n", url, err)
-			m.Pending.Enqueue(te)
-			return
-		}
+// dispatched records how the worker took a task event sent by SendWork
+func (m *Manager) dispatched(d *dispatch, reply workerReply) {
+	te, t, w := d.te, d.t, d.w
+	if reply.err != nil {
+		log.Printf("[Manager] Error while connecting to %s: %v\n", w, reply.err)
+		m.recordTimeline(t.ID, t.State, task.TimelineRequeued, w, reply.err.Error())
+		m.Pending.Enqueue(te)
+		return
+	}
 
-		d := json.NewDecoder(resp.Body)
-		if resp.StatusCode != http.StatusCreated {
-			e := worker.ErrResponse{}
-			err := d.Decode(&d)
-			if err != nil {
-				log.Printf("[Manager] Error while decoding response: %v\n", err)
-				return
-			}
-			log.Printf("[Manager] Response error (%d): %s\n", resp.StatusCode, e.Message)
-			return
-		}
-		t = task.Task{}
-		err = d.Decode(&t)
+	if reply.status != http.StatusCreated {
+		e := worker.ErrResponse{}
+		err := json.Unmarshal(reply.body, &e)
 		if err != nil {
 			log.Printf("[Manager] Error while decoding response: %v\n", err)
 			return
 		}
-		log.Printf("%#v\n", t)
-	} else {
-		log.Println("[Manager] No tasks in the queue")
+		log.Printf("[Manager] Response error (%d): %s\n", reply.status, e.Message)
+		m.recordTimeline(t.ID, t.State, task.TimelineRejected, w, e.Message)
+		if rejected, ok := m.TaskDb[t.ID]; ok && te.State != task.Completed && rejected.State == task.Scheduled {
+			// the worker won't run it, so it goes back to pending, to be placed again
+			m.unplaceTask(t.ID, w)
+			rejected.State = task.Pending
+			rejected.PendingReason = "" // so that the change gets published
+			m.recordPending(*rejected, fmt.Sprintf("rejected by node %s: %s", w, e.Message))
+			m.Pending.Enqueue(te)
+		}
+		return
+	}
+	if te.State != task.Completed {
+		m.recordTimeline(t.ID, t.State, task.TimelineScheduled, w, fmt.Sprintf("scheduled to node %s", w))
+	} else if stopped, ok := m.TaskDb[t.ID]; ok {
+		m.publishTask(WatchDeleted, stopped)
+	}
+	t = task.Task{}
+	err := json.Unmarshal(reply.body, &t)
+	if err != nil {
+		log.Printf("[Manager] Error while decoding response: %v\n", err)
+		return
 	}
+	log.Printf("%#v\n", t)
+}
+
+// finishUnplaced stops a task that was never sent to a worker
+func (m *Manager) finishUnplaced(id uuid.UUID) {
+	delete(m.stopping, id)
+	t, ok := m.TaskDb[id]
+	if !ok {
+		return
+	}
+	t.State = task.Completed
+	t.PendingReason = ""
+	t.FinishTime = time.Now().UTC()
+	m.recordTimeline(id, task.Completed, task.TimelineStopped, "", "stopped before it was scheduled")
+	m.publishTask(WatchDeleted, t)
 }
 
 func (m *Manager) DoHealthChecks() {
@@ -203,21 +475,29 @@ func (m *Manager) DoHealthChecks() {
 	}
 }
 
-func (m *Manager) checkTaskHealth(t task.Task) error {
-	log.Printf("[Manager] Checking health for task %s\n", t.ID)
+// healthCheckURL is where the task's health check is served, empty when it exposes no ports
+func (m *Manager) healthCheckURL(t task.Task) string {
 	if t.HostPorts == nil {
-		return nil
+		return ""
 	}
 	w := m.TaskWorkerMap[t.ID]
 	hostPort := getHostPort(t.HostPorts)
 	worker := strings.Split(w, ":")
-	url := fmt.Sprintf("http://%s:%s%s", worker[0], *hostPort, t.HealthCheck)
+	return fmt.Sprintf("http://%s:%s%s", worker[0], *hostPort, t.HealthCheck)
+}
+
+func checkTaskHealth(t task.Task, url string) error {
+	log.Printf("[Manager] Checking health for task %s\n", t.ID)
+	if url == "" {
+		return nil
+	}
 	log.Printf("[Manager] Calling health check for task %s at %s\n", t.ID, url)
-	resp, err := http.Get(url)
+	resp, err := workerClient.Get(url)
 	if err != nil {
 		log.Printf("[Manager] Error connecting to health check, %s\n", err.Error())
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		msg := fmt.Sprintf("[Manager] Response status code was %d when %d was expected", resp.StatusCode, http.StatusOK)
 		log.Println(msg)
@@ -227,24 +507,69 @@ func (m *Manager) checkTaskHealth(t task.Task) error {
 	return nil
 }
 
+// doHealthChecks calls the health checks, and the workers of tasks to restart,
+// without holding the lock: a slow task mustn't hold up the manager
 func (m *Manager) doHealthChecks() {
-	for _, t := range m.GetTasks() {
-		if t.State == task.Running {
-			err := m.checkTaskHealth(*t)
-			if err != nil && t.RestartCount < 3 {
-				m.restartTask(t)
+	urls := make(map[uuid.UUID]string)
+	var running []task.Task
+	var restarts []*dispatch
+	m.locked(func() {
+		for _, t := range m.GetTasks() {
+			if t.State != task.Running {
+				delete(m.healthy, t.ID)
+			}
+			if m.isWorkerLost(m.TaskWorkerMap[t.ID]) || m.stopping[t.ID] {
+				continue
 			}
-		} else if t.State == task.Failed && t.RestartCount < 3 {
-			m.restartTask(t)
+			if t.RunsToCompletion() || t.GroupID != uuid.Nil {
+				// retries of batch tasks are up to their job or workflow, groups don't retry
+				continue
+			}
+			if t.State == task.Running {
+				running = append(running, *t)
+				urls[t.ID] = m.healthCheckURL(*t)
+			} else if t.State == task.Failed && t.RestartCount < 3 {
+				restarts = append(restarts, m.restartTask(t))
+			}
+		}
+	})
+	for _, checked := range running {
+		err := checkTaskHealth(checked, urls[checked.ID])
+		m.locked(func() {
+			t, ok := m.TaskDb[checked.ID]
+			if !ok || t.State != task.Running || m.stopping[t.ID] {
+				// it has moved on while it was being checked
+				return
+			}
+			m.healthy[t.ID] = err == nil
+			if err == nil {
+				return
+			}
+			m.recordTimeline(t.ID, t.State, task.TimelineHealthCheckFailed, m.TaskWorkerMap[t.ID], err.Error())
+			if t.RestartCount < 3 {
+				restarts = append(restarts, m.restartTask(t))
+			}
+		})
+	}
+	for _, d := range restarts {
+		if d == nil {
+			continue
 		}
+		reply := d.deliver()
+		m.locked(func() { m.restarted(d, reply) })
 	}
 }
 
-func (m *Manager) restartTask(t *task.Task) {
+// restartTask marks the task as scheduled again and returns the event that
+// asks its worker to run it, for the caller to deliver once it lets go of the lock
+func (m *Manager) restartTask(t *task.Task) *dispatch {
 	w := m.TaskWorkerMap[t.ID]
 	t.State = task.Scheduled
 	t.RestartCount++
+	t.FailureReason = ""
+	t.FailureMessage = ""
 	m.TaskDb[t.ID] = t
+	m.publishTask(WatchUpdated, t)
 
 	te := task.TaskEvent{
 		ID:        uuid.New(),
@@ -252,23 +577,36 @@ func (m *Manager) restartTask(t *task.Task) {
 		Timestamp: time.Now(),
 		Task:      *t,
 	}
-	data, err := json.Marshal(te)
+	payload, err := m.withSecrets(te)
 	if err != nil {
-		log.Printf("[Manager] Error while marshalling task event: %s\n", err)
-		return
+		log.Printf("[Manager] Error restarting task %s: %v\n", t.ID, err)
+		m.recordPending(*t, err.Error())
+		m.Pending.Enqueue(te)
+		return nil
 	}
-	url := fmt.Sprintf("http://%s/tasks", w)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	t.Configs = payload.Task.Configs
+	te.Task = *t
+	data, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("[Manager] Error connecting to %s: %s\n", w, err.Error())
-		m.Pending.Enqueue(te)
+		log.Printf("[Manager] Error while marshalling task event: %s\n", err)
+		return nil
+	}
+	return &dispatch{te: te, t: *t, w: w, data: data}
+}
+
+// restarted records how the worker took a restart
+func (m *Manager) restarted(d *dispatch, reply workerReply) {
+	t, w := d.t, d.w
+	if reply.err != nil {
+		log.Printf("[Manager] Error connecting to %s: %s\n", w, reply.err.Error())
+		m.recordTimeline(t.ID, t.State, task.TimelineRequeued, w, reply.err.Error())
+		m.Pending.Enqueue(d.te)
 		return
 	}
 
-	d := json.NewDecoder(resp.Body)
-	if resp.StatusCode != http.StatusCreated {
+	if reply.status != http.StatusCreated {
 		e := worker.ErrResponse{}
-		err := d.Decode(&e)
+		err := json.Unmarshal(reply.body, &e)
 		if err != nil {
 			log.Printf("[Manager] Error while decoding error response body: %s\n", err.Error())
 			return
@@ -278,15 +616,71 @@ func (m *Manager) restartTask(t *task.Task) {
 	}
 
 	newTask := task.Task{}
-	err = d.Decode(&newTask)
+	err := json.Unmarshal(reply.body, &newTask)
 	if err != nil {
 		log.Printf("[Manager] Error while decoding success response body: %s\n", err.Error())
 		return
 	}
 
+	m.recordTimeline(t.ID, t.State, task.TimelineRestarted, w, fmt.Sprintf("restart %d of 3", t.RestartCount))
 	log.Printf("[Manager] Restarted task %s", t.ID)
 }
 
+// dispatch is a task event on its way to a worker, along with the task as the
+// manager had it when sending
+type dispatch struct {
+	te   task.TaskEvent
+	t    task.Task
+	w    string
+	data []byte
+}
+
+// workerReply is how a worker answered a dispatch
+type workerReply struct {
+	status int
+	body   []byte
+	err    error
+}
+
+// deliver posts the event to its worker, it's meant to be called without the lock
+func (d *dispatch) deliver() workerReply {
+	url := fmt.Sprintf("http://%s/tasks", d.w)
+	resp, err := workerClient.Post(url, "application/json", bytes.NewBuffer(d.data))
+	if err != nil {
+		return workerReply{err: err}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		// the worker got the event anyway, sending it again would run it twice
+		log.Printf("[Manager] Error while reading response from %s: %v\n", d.w, err)
+	}
+	return workerReply{status: resp.StatusCode, body: body}
+}
+
+// withSecrets adds what the worker needs besides the task to the event: secret
+// values, config contents and registry credentials. The task gets pinned to the
+// config versions it's given. EventDb keeps events without any of it.
+func (m *Manager) withSecrets(te task.TaskEvent) (task.TaskEvent, error) {
+	payload := te
+	t := te.Task
+	var err error
+	if payload.Secrets, err = m.resolveSecrets(t); err != nil {
+		return te, err
+	}
+	if payload.Configs, err = m.resolveConfigs(&t); err != nil {
+		return te, err
+	}
+	if payload.RegistryAuth, err = m.registryAuth(t.Image); err != nil {
+		return te, err
+	}
+	if payload.InitRegistryAuth, err = m.initRegistryAuth(t); err != nil {
+		return te, err
+	}
+	payload.Task = t
+	return payload, nil
+}
+
 func getHostPort(ports nat.PortMap) *string {
 	for k, _ := range ports {
 		return &ports[k][0].HostPort
@@ -0,0 +1,84 @@
+package manager
+
+import (
+	"container/heap"
+
+	"kjarmicki.github.com/cube/task"
+)
+
+// PendingQueue hands out task events by priority. Stop events come first, since
+// they free resources for everything else, then the highest task priority wins.
+// Events of equal priority keep their submission order.
+type PendingQueue struct {
+	events pendingHeap
+	seq    int
+	last   pendingEvent   // most recently dequeued
+	parked []pendingEvent // set aside until Unpark
+}
+
+type pendingEvent struct {
+	te  task.TaskEvent
+	seq int
+}
+
+func (q *PendingQueue) Enqueue(te task.TaskEvent) {
+	q.seq++
+	heap.Push(&q.events, pendingEvent{te: te, seq: q.seq})
+}
+
+func (q *PendingQueue) Dequeue() task.TaskEvent {
+	q.last = heap.Pop(&q.events).(pendingEvent)
+	return q.last.te
+}
+
+// Park sets aside an event that can't be placed right now, so that the ones
+// behind it get their turn. It keeps its place in line for when it's unparked.
+func (q *PendingQueue) Park(te task.TaskEvent) {
+	if q.last.te.ID == te.ID {
+		q.parked = append(q.parked, pendingEvent{te: te, seq: q.last.seq})
+		return
+	}
+	q.seq++
+	q.parked = append(q.parked, pendingEvent{te: te, seq: q.seq})
+}
+
+// Unpark puts the parked events back in the queue
+func (q *PendingQueue) Unpark() {
+	for _, e := range q.parked {
+		heap.Push(&q.events, e)
+	}
+	q.parked = nil
+}
+
+func (q *PendingQueue) Len() int {
+	return q.events.Len()
+}
+
+type pendingHeap []pendingEvent
+
+func (h pendingHeap) Len() int { return len(h) }
+
+func (h pendingHeap) Less(i, j int) bool {
+	stopI, stopJ := h[i].te.State == task.Completed, h[j].te.State == task.Completed
+	if stopI != stopJ {
+		return stopI
+	}
+	if h[i].te.Task.Priority != h[j].te.Task.Priority {
+		return h[i].te.Task.Priority > h[j].te.Task.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h pendingHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *pendingHeap) Push(x any) {
+	*h = append(*h, x.(pendingEvent))
+}
+
+func (h *pendingHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	*h = old[:n-1]
+	return e
+}
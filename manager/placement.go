@@ -0,0 +1,371 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/node"
+	"kjarmicki.github.com/cube/task"
+	"kjarmicki.github.com/cube/worker"
+)
+
+// resources requested by tasks and offered by nodes, a zero capacity stands for unknown
+type resources struct {
+	cpu    float64
+	memory int
+	disk   int
+}
+
+func taskResources(t task.Task) resources {
+	if t.InfraTaskID != uuid.Nil {
+		// the infra task reserves for its whole group
+		return resources{}
+	}
+	return resources{cpu: t.Cpu, memory: t.Memory, disk: t.Disk}
+}
+
+func nodeCapacity(n *node.Node) resources {
+	return resources{cpu: float64(n.Cores), memory: n.Memory, disk: n.Disk}
+}
+
+func (r resources) add(o resources) resources {
+	return resources{cpu: r.cpu + o.cpu, memory: r.memory + o.memory, disk: r.disk + o.disk}
+}
+
+func (r resources) sub(o resources) resources {
+	return resources{cpu: r.cpu - o.cpu, memory: r.memory - o.memory, disk: r.disk - o.disk}
+}
+
+// within tells whether r fits into capacity, ignoring whatever capacity isn't known
+func (r resources) within(capacity resources) bool {
+	return (capacity.cpu == 0 || r.cpu <= capacity.cpu) &&
+		(capacity.memory == 0 || r.memory <= capacity.memory) &&
+		(capacity.disk == 0 || r.disk <= capacity.disk)
+}
+
+func (r resources) String() string {
+	var parts []string
+	if r.cpu > 0 {
+		parts = append(parts, fmt.Sprintf("%g cores", r.cpu))
+	}
+	if r.memory > 0 {
+		parts = append(parts, fmt.Sprintf("%d bytes of memory", r.memory))
+	}
+	if r.disk > 0 {
+		parts = append(parts, fmt.Sprintf("%d bytes of disk", r.disk))
+	}
+	if len(parts) == 0 {
+		return "no resources"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// placeTask picks a worker with room for the task and reserves its resources there.
+// When there's none and preemption is on, lower priority tasks are evicted to make
+// room and the task waits for them to stop.
+func (m *Manager) placeTask(t task.Task) (string, bool) {
+	if reason, ok := m.exceedsEveryNode(t); ok {
+		m.recordPending(t, reason)
+		return "", false
+	}
+	pinned, reason := m.pinnedWorker(t)
+	if reason != "" {
+		m.recordPending(t, reason)
+		return "", false
+	}
+	if pinned != "" {
+		if m.fits(pinned, t) {
+			m.reserve(pinned, t)
+			return pinned, true
+		}
+		reason = fmt.Sprintf("node %s holding its volumes doesn't have %s free", pinned, taskResources(t))
+	} else if w := m.workerWithImage(t); w != "" {
+		m.reserve(w, t)
+		m.claimVolumes(w, t)
+		return w, true
+	} else {
+		for range m.Workers {
+			w := m.SelectWorker()
+			if !m.isWorkerLost(w) && m.fits(w, t) {
+				m.reserve(w, t)
+				m.claimVolumes(w, t)
+				return w, true
+			}
+		}
+		reason = fmt.Sprintf("no node has %s free", taskResources(t))
+	}
+	if m.Preemption {
+		if w, victims := m.findVictims(t, pinned); len(victims) > 0 {
+			for _, v := range victims {
+				m.evictTask(v, t, w)
+			}
+			reason = fmt.Sprintf("waiting for %d lower priority tasks to leave node %s", len(victims), w)
+		}
+	}
+	m.recordPending(t, reason)
+	return "", false
+}
+
+// workerWithImage finds the least busy worker that has room for the task and
+// already has its image, so that the task starts without pulling it
+func (m *Manager) workerWithImage(t task.Task) string {
+	img := task.NormalizeImage(t.Image)
+	var best *node.Node
+	for _, w := range m.Workers {
+		n := m.workerNode(w)
+		if m.isWorkerLost(w) || n == nil || !slices.Contains(n.Images, img) || !m.fits(w, t) {
+			continue
+		}
+		if best == nil || n.TaskCount < best.TaskCount {
+			best = n
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.Name
+}
+
+// tasks bigger than every node would otherwise wait forever without anyone knowing why
+func (m *Manager) exceedsEveryNode(t task.Task) (string, bool) {
+	requested := taskResources(t)
+	var largest resources
+	for _, w := range m.Workers {
+		n := m.workerNode(w)
+		if m.isWorkerLost(w) || n == nil {
+			continue
+		}
+		capacity := nodeCapacity(n)
+		if requested.within(capacity) {
+			return "", false
+		}
+		largest.cpu = max(largest.cpu, capacity.cpu)
+		largest.memory = max(largest.memory, capacity.memory)
+		largest.disk = max(largest.disk, capacity.disk)
+	}
+	if largest == (resources{}) {
+		// no node is reachable, which isn't the task's fault
+		return "", false
+	}
+	return fmt.Sprintf("requests %s, more than any node has (at most %s)", requested, largest), true
+}
+
+func (m *Manager) workerNode(w string) *node.Node {
+	for _, n := range m.WorkerNodes {
+		if n.Name == w {
+			return n
+		}
+	}
+	return nil
+}
+
+func (m *Manager) fits(w string, t task.Task) bool {
+	n := m.workerNode(w)
+	if n == nil {
+		return true
+	}
+	m.refreshAllocation(w)
+	return m.allocated(n).add(taskResources(t)).within(nodeCapacity(n))
+}
+
+func (m *Manager) allocated(n *node.Node) resources {
+	return resources{cpu: n.CpuAllocated, memory: n.MemoryAllocated, disk: n.DiskAllocated}
+}
+
+// reserve accounts for a task placed on a worker right away, so that the
+// next placement sees it even before the worker reports the task back
+func (m *Manager) reserve(w string, t task.Task) {
+	n := m.workerNode(w)
+	if n == nil {
+		return
+	}
+	used := m.allocated(n).add(taskResources(t))
+	n.CpuAllocated = used.cpu
+	n.MemoryAllocated = used.memory
+	n.DiskAllocated = used.disk
+	n.TaskCount++
+}
+
+// refreshAllocation recomputes what's reserved on a worker from the tasks placed
+// there. Tasks release their reservation once they complete or fail for good, so
+// whatever was missed along the way doesn't leak. Tasks asked to stop keep theirs
+// until the worker reports them gone, they still take up the node until then.
+func (m *Manager) refreshAllocation(w string) {
+	n := m.workerNode(w)
+	if n == nil {
+		return
+	}
+	var used resources
+	count := 0
+	for _, id := range m.WorkerTaskMap[w] {
+		if t, ok := m.TaskDb[id]; ok && m.holdsResources(t) {
+			used = used.add(taskResources(*t))
+			count++
+		}
+	}
+	n.CpuAllocated = used.cpu
+	n.MemoryAllocated = used.memory
+	n.DiskAllocated = used.disk
+	n.TaskCount = count
+}
+
+func (m *Manager) holdsResources(t *task.Task) bool {
+	if m.stopping[t.ID] && (t.State == task.Scheduled || t.State == task.Running) {
+		return !m.isWorkerLost(m.TaskWorkerMap[t.ID])
+	}
+	return m.isTaskLive(t)
+}
+
+// tasks that run, or are about to run, on a worker
+func (m *Manager) workerTasks(w string) []*task.Task {
+	var tasks []*task.Task
+	for _, id := range m.WorkerTaskMap[w] {
+		if t, ok := m.TaskDb[id]; ok && m.isTaskLive(t) {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks
+}
+
+// findVictims looks for the worker where the fewest lower priority tasks
+// have to be evicted for the task to fit, only looking at the pinned one if it's set
+func (m *Manager) findVictims(t task.Task, pinned string) (string, []*task.Task) {
+	requested := taskResources(t)
+	var best string
+	var bestVictims []*task.Task
+	for _, w := range m.Workers {
+		if pinned != "" && w != pinned {
+			continue
+		}
+		n := m.workerNode(w)
+		if m.isWorkerLost(w) || n == nil || !requested.within(nodeCapacity(n)) {
+			continue
+		}
+		var candidates []*task.Task
+		for _, running := range m.workerTasks(w) {
+			if running.Priority < t.Priority {
+				candidates = append(candidates, running)
+			}
+		}
+		// least important first, and among those the ones that started last
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].Priority != candidates[j].Priority {
+				return candidates[i].Priority < candidates[j].Priority
+			}
+			return candidates[i].StartTime.After(candidates[j].StartTime)
+		})
+		m.refreshAllocation(w)
+		used := m.allocated(n).add(requested)
+		var victims []*task.Task
+		for _, c := range candidates {
+			if used.within(nodeCapacity(n)) {
+				break
+			}
+			victims = append(victims, c)
+			used = used.sub(taskResources(*c))
+		}
+		if !used.within(nodeCapacity(n)) {
+			continue
+		}
+		// spare the victims that turned out not to be needed once bigger ones were added
+		for i := 0; i < len(victims); {
+			if spared := used.add(taskResources(*victims[i])); spared.within(nodeCapacity(n)) {
+				used = spared
+				victims = append(victims[:i], victims[i+1:]...)
+				continue
+			}
+			i++
+		}
+		if best == "" || len(victims) < len(bestVictims) {
+			best, bestVictims = w, victims
+		}
+	}
+	return best, bestVictims
+}
+
+func (m *Manager) evictTask(victim *task.Task, by task.Task, w string) {
+	reason := fmt.Sprintf("preempted by task %s with priority %d", by.ID, by.Priority)
+	m.recordTimeline(victim.ID, victim.State, task.TimelineEvicted, w, reason)
+	log.Printf("[Manager] Evicting task %s from %s: %s\n", victim.ID, w, reason)
+	m.stopTask(victim, reason)
+}
+
+// unplaceTask forgets where a task was placed, releasing what it reserved there
+func (m *Manager) unplaceTask(id uuid.UUID, w string) {
+	m.WorkerTaskMap[w] = slices.DeleteFunc(m.WorkerTaskMap[w], func(placed uuid.UUID) bool {
+		return placed == id
+	})
+	delete(m.TaskWorkerMap, id)
+	m.refreshAllocation(w)
+}
+
+// records why a task is still waiting, unless that's what the task already says
+func (m *Manager) recordPending(t task.Task, reason string) {
+	if pending, ok := m.TaskDb[t.ID]; ok {
+		if pending.PendingReason == reason {
+			return
+		}
+		pending.PendingReason = reason
+		m.publishTask(WatchUpdated, pending)
+	}
+	log.Printf("[Manager] Task %s stays pending: %s\n", t.ID, reason)
+	m.recordTimeline(t.ID, task.Pending, task.TimelineRequeued, "", reason)
+}
+
+func (m *Manager) GetNodes() []*node.Node {
+	for _, w := range m.Workers {
+		m.refreshAllocation(w)
+	}
+	return m.WorkerNodes
+}
+
+// updateNodes learns the capacity and volumes of every worker. The workers are
+// asked without holding the lock, so that a slow one doesn't hold up the manager.
+func (m *Manager) updateNodes() {
+	for _, w := range m.Workers {
+		url := fmt.Sprintf("http://%s/stats", w)
+		resp, err := workerClient.Get(url)
+		if err != nil {
+			log.Printf("[Manager] Error while connecting to %s for stats\n", w)
+			continue
+		}
+		stats := worker.Stats{}
+		err = json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		if err != nil {
+			log.Printf("[Manager] Error while decoding stats of %s: %v\n", w, err)
+			continue
+		}
+		volumes, volumesErr := fetchVolumes(w)
+		m.locked(func() {
+			n := m.workerNode(w)
+			if n == nil {
+				return
+			}
+			// the kernel reports memory in kB
+			n.Memory = stats.MemStats.MemTotal * 1024
+			n.Disk = int(stats.DiskStats.All)
+			n.Cores = stats.Cores
+			n.Images = stats.Images
+			m.refreshAllocation(w)
+			if volumesErr == nil {
+				m.syncVolumes(w, volumes)
+			}
+		})
+	}
+}
+
+func (m *Manager) UpdateNodes() {
+	for {
+		log.Println("[Manager] Collecting stats from workers")
+		m.updateNodes()
+		log.Println("[Manager] Stats collected, sleeping for 15 seconds")
+		time.Sleep(15 * time.Second)
+	}
+}
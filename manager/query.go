@@ -0,0 +1,198 @@
+package manager
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/task"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// every sortable field maps a task onto a string that orders lexicographically
+var sortKeys = map[string]func(t *task.Task) string{
+	"id":          func(t *task.Task) string { return t.ID.String() },
+	"name":        func(t *task.Task) string { return t.Name },
+	"state":       func(t *task.Task) string { return fmt.Sprintf("%04d", int(t.State)) },
+	"start_time":  func(t *task.Task) string { return timeKey(t.StartTime) },
+	"finish_time": func(t *task.Task) string { return timeKey(t.FinishTime) },
+}
+
+// unset times get an empty key, which sorts last whichever the direction
+func timeKey(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+type TaskQuery struct {
+	States     []task.State
+	Node       string
+	NamePrefix string
+	Labels     map[string]string
+	SortBy     string // one of sortKeys, prefixed with "-" for descending order
+	Limit      int
+	Cursor     string // opaque value returned as NextCursor by the previous page
+}
+
+type TaskPage struct {
+	Tasks      []*task.Task
+	NextCursor string
+}
+
+// ParseTaskQuery builds a query out of URL parameters:
+// ?state=running&node=host:port&name_prefix=web&label=tier=frontend&sort=-start_time&limit=10&cursor=...
+func ParseTaskQuery(v url.Values) (TaskQuery, error) {
+	q := TaskQuery{
+		Node:       v.Get("node"),
+		NamePrefix: v.Get("name_prefix"),
+		Labels:     make(map[string]string),
+		SortBy:     v.Get("sort"),
+		Limit:      defaultQueryLimit,
+		Cursor:     v.Get("cursor"),
+	}
+	for _, s := range v["state"] {
+		state, err := task.ParseState(s)
+		if err != nil {
+			return q, err
+		}
+		q.States = append(q.States, state)
+	}
+	for _, l := range v["label"] {
+		k, val, ok := strings.Cut(l, "=")
+		if !ok || k == "" {
+			return q, fmt.Errorf("label selector %q should look like key=value", l)
+		}
+		q.Labels[k] = val
+	}
+	if q.SortBy == "" {
+		q.SortBy = "id"
+	}
+	if _, ok := sortKeys[strings.TrimPrefix(q.SortBy, "-")]; !ok {
+		return q, fmt.Errorf("cannot sort by %q", q.SortBy)
+	}
+	if l := v.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxQueryLimit {
+			return q, fmt.Errorf("limit should be a number between 1 and %d", maxQueryLimit)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+func (q TaskQuery) matches(t *task.Task, node string) bool {
+	if len(q.States) > 0 && !task.Contains(q.States, t.State) {
+		return false
+	}
+	if q.Node != "" && q.Node != node {
+		return false
+	}
+	if !strings.HasPrefix(t.Name, q.NamePrefix) {
+		return false
+	}
+	for k, v := range q.Labels {
+		if t.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// cursor carries the sort key and ID of the last task on the previous page,
+// so paging stays stable when tasks are added in the meantime
+func encodeCursor(key string, id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key + "\x00" + id.String()))
+}
+
+func decodeCursor(c string) (string, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil {
+		return "", "", errors.New("malformed cursor")
+	}
+	key, id, ok := strings.Cut(string(raw), "\x00")
+	if !ok {
+		return "", "", errors.New("malformed cursor")
+	}
+	return key, id, nil
+}
+
+func (m *Manager) QueryTasks(q TaskQuery) (TaskPage, error) {
+	desc := strings.HasPrefix(q.SortBy, "-")
+	keyOf := sortKeys[strings.TrimPrefix(q.SortBy, "-")]
+	if keyOf == nil {
+		return TaskPage{}, fmt.Errorf("cannot sort by %q", q.SortBy)
+	}
+	limit := q.Limit
+	if limit < 1 {
+		limit = defaultQueryLimit
+	}
+
+	type entry struct {
+		key  string
+		id   string
+		task *task.Task
+	}
+	var entries []entry
+	for _, t := range m.GetTasks() {
+		if q.matches(t, m.TaskWorkerMap[t.ID]) {
+			entries = append(entries, entry{key: keyOf(t), id: t.ID.String(), task: t})
+		}
+	}
+	less := func(aKey, aID, bKey, bID string) bool {
+		if aKey != bKey {
+			if aKey == "" || bKey == "" {
+				return bKey == ""
+			}
+			return (aKey < bKey) != desc
+		}
+		if aID != bID {
+			return (aID < bID) != desc
+		}
+		return false
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return less(entries[i].key, entries[i].id, entries[j].key, entries[j].id)
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		cKey, cID, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return TaskPage{}, err
+		}
+		start = sort.Search(len(entries), func(i int) bool {
+			return less(cKey, cID, entries[i].key, entries[i].id)
+		})
+	}
+
+	page := TaskPage{Tasks: []*task.Task{}}
+	end := start + limit
+	if end > len(entries) {
+		end = len(entries)
+	}
+	for _, e := range entries[start:end] {
+		page.Tasks = append(page.Tasks, e.task)
+	}
+	if end < len(entries) {
+		last := entries[end-1]
+		page.NextCursor = encodeCursor(last.key, last.task.ID)
+	}
+	return page, nil
+}
+
+func (m *Manager) GetTask(id uuid.UUID) (*task.Task, bool) {
+	t, ok := m.TaskDb[id]
+	return t, ok
+}
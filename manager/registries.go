@@ -0,0 +1,125 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"kjarmicki.github.com/cube/registry"
+	"kjarmicki.github.com/cube/secret"
+	"kjarmicki.github.com/cube/task"
+)
+
+// AddRegistry stores credentials for a registry, replacing the ones it had before
+func (m *Manager) AddRegistry(c registry.Credential) (registry.Credential, error) {
+	if err := c.Validate(); err != nil {
+		return registry.Credential{}, err
+	}
+	if m.Registries == nil {
+		return registry.Credential{}, ErrSecretsDisabled
+	}
+	c.CreatedAt = time.Now().UTC()
+	value, err := json.Marshal(c)
+	if err != nil {
+		return registry.Credential{}, err
+	}
+	name := registry.StoreKey(c.Server)
+	if _, ok := m.Registries.Get(name); ok {
+		_, err = m.Registries.Update(name, value)
+	} else {
+		_, err = m.Registries.Create(name, value)
+	}
+	if err != nil {
+		return registry.Credential{}, err
+	}
+	log.Printf("[Manager] Added credentials for registry %s\n", c.Server)
+	return c.Redacted(), nil
+}
+
+func (m *Manager) GetRegistries() []registry.Credential {
+	credentials := []registry.Credential{}
+	if m.Registries == nil {
+		return credentials
+	}
+	// entries come sorted by name, which sorts them by server too
+	for _, s := range m.Registries.List() {
+		c, err := m.credential(s.Name)
+		if err != nil {
+			log.Printf("[Manager] Error reading credentials for %s: %v\n", s.Name, err)
+			continue
+		}
+		credentials = append(credentials, c.Redacted())
+	}
+	return credentials
+}
+
+func (m *Manager) RemoveRegistry(server string) error {
+	server = registry.NormalizeServer(server)
+	if m.Registries == nil {
+		return ErrSecretsDisabled
+	}
+	if err := m.Registries.Delete(registry.StoreKey(server)); err != nil {
+		if errors.Is(err, secret.ErrNotFound) {
+			return fmt.Errorf("no credentials for registry %s", server)
+		}
+		return err
+	}
+	log.Printf("[Manager] Removed credentials for registry %s\n", server)
+	return nil
+}
+
+// registryAuth finds the credentials for pulling an image, if there are any
+func (m *Manager) registryAuth(image string) (string, error) {
+	server, err := registry.Server(image)
+	if err != nil {
+		return "", err
+	}
+	if m.Registries == nil {
+		return "", nil
+	}
+	c, err := m.credential(registry.StoreKey(server))
+	if errors.Is(err, secret.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return c.Encode()
+}
+
+// initRegistryAuth finds the credentials for the images of a task's init
+// containers, by init container name. Those using the task's image get its own.
+func (m *Manager) initRegistryAuth(t task.Task) (map[string]string, error) {
+	var auths map[string]string
+	for _, c := range t.InitContainers {
+		if c.Image == "" {
+			continue
+		}
+		auth, err := m.registryAuth(c.Image)
+		if err != nil {
+			return nil, fmt.Errorf("init container %s: %w", c.Name, err)
+		}
+		if auth == "" {
+			continue
+		}
+		if auths == nil {
+			auths = make(map[string]string)
+		}
+		auths[c.Name] = auth
+	}
+	return auths, nil
+}
+
+func (m *Manager) credential(name string) (registry.Credential, error) {
+	c := registry.Credential{}
+	value, err := m.Registries.Reveal(name)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(value, &c); err != nil {
+		return c, fmt.Errorf("invalid credentials stored for %s: %w", name, err)
+	}
+	return c, nil
+}
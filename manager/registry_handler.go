@@ -0,0 +1,54 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"kjarmicki.github.com/cube/registry"
+)
+
+func (a *Api) CreateRegistryHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	c := registry.Credential{}
+	if err := d.Decode(&c); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	created, err := a.Manager.AddRegistry(c)
+	if errors.Is(err, ErrSecretsDisabled) {
+		writeSecretError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid registry credentials: %v", err))
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(created)
+}
+
+// GetRegistriesHandler lists registries with credentials, leaving out the passwords
+func (a *Api) GetRegistriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.GetRegistries())
+}
+
+func (a *Api) DeleteRegistryHandler(w http.ResponseWriter, r *http.Request) {
+	err := a.Manager.RemoveRegistry(chi.URLParam(r, "server"))
+	if errors.Is(err, ErrSecretsDisabled) {
+		writeSecretError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, 404, err.Error())
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,107 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"kjarmicki.github.com/cube/secret"
+)
+
+// SecretRequest carries the value of a secret, base64 encoded in JSON
+type SecretRequest struct {
+	Name  string
+	Value []byte
+}
+
+func (a *Api) CreateSecretHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeSecretRequest(w, r)
+	if !ok {
+		return
+	}
+	s, err := a.Manager.AddSecret(req.Name, req.Value)
+	if err != nil {
+		writeSecretError(w, err)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(s)
+}
+
+func (a *Api) GetSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.Manager.Secrets == nil {
+		writeSecretError(w, ErrSecretsDisabled)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.Secrets.List())
+}
+
+// GetSecretHandler describes a secret, without ever revealing its value
+func (a *Api) GetSecretHandler(w http.ResponseWriter, r *http.Request) {
+	if a.Manager.Secrets == nil {
+		writeSecretError(w, ErrSecretsDisabled)
+		return
+	}
+	s, ok := a.Manager.Secrets.Get(chi.URLParam(r, "name"))
+	if !ok {
+		writeSecretError(w, secret.ErrNotFound)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(s)
+}
+
+func (a *Api) UpdateSecretHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeSecretRequest(w, r)
+	if !ok {
+		return
+	}
+	s, err := a.Manager.UpdateSecret(chi.URLParam(r, "name"), req.Value)
+	if err != nil {
+		writeSecretError(w, err)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(s)
+}
+
+func (a *Api) DeleteSecretHandler(w http.ResponseWriter, r *http.Request) {
+	if err := a.Manager.RemoveSecret(chi.URLParam(r, "name")); err != nil {
+		writeSecretError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func decodeSecretRequest(w http.ResponseWriter, r *http.Request) (SecretRequest, bool) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := SecretRequest{}
+	if err := d.Decode(&req); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return req, false
+	}
+	return req, true
+}
+
+func writeSecretError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrSecretsDisabled):
+		writeError(w, 503, err.Error())
+	case errors.Is(err, secret.ErrNotFound):
+		writeError(w, 404, err.Error())
+	case errors.Is(err, secret.ErrExists), errors.Is(err, ErrSecretInUse):
+		writeError(w, 409, err.Error())
+	default:
+		writeError(w, 400, err.Error())
+	}
+}
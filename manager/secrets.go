@@ -0,0 +1,81 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"kjarmicki.github.com/cube/secret"
+	"kjarmicki.github.com/cube/task"
+)
+
+var (
+	ErrSecretsDisabled = errors.New("secrets store is not configured")
+	ErrSecretInUse     = errors.New("secret is in use")
+)
+
+// resolveSecrets reveals the values of the secrets referenced by a task
+func (m *Manager) resolveSecrets(t task.Task) (map[string][]byte, error) {
+	names := t.SecretNames()
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if m.Secrets == nil {
+		return nil, ErrSecretsDisabled
+	}
+	values := make(map[string][]byte, len(names))
+	for _, name := range names {
+		value, err := m.Secrets.Reveal(name)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %w", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+func (m *Manager) AddSecret(name string, value []byte) (secret.Secret, error) {
+	if m.Secrets == nil {
+		return secret.Secret{}, ErrSecretsDisabled
+	}
+	s, err := m.Secrets.Create(name, value)
+	if err != nil {
+		return s, err
+	}
+	log.Printf("[Manager] Added secret %s\n", name)
+	return s, nil
+}
+
+// UpdateSecret stores a new value, which tasks get the next time they're started
+func (m *Manager) UpdateSecret(name string, value []byte) (secret.Secret, error) {
+	if m.Secrets == nil {
+		return secret.Secret{}, ErrSecretsDisabled
+	}
+	s, err := m.Secrets.Update(name, value)
+	if err != nil {
+		return s, err
+	}
+	log.Printf("[Manager] Updated secret %s to version %d\n", name, s.Version)
+	return s, nil
+}
+
+// RemoveSecret deletes a secret no running task refers to
+func (m *Manager) RemoveSecret(name string) error {
+	if m.Secrets == nil {
+		return ErrSecretsDisabled
+	}
+	if _, ok := m.Secrets.Get(name); !ok {
+		return secret.ErrNotFound
+	}
+	for _, t := range m.TaskDb {
+		if m.isTaskLive(t) && slices.Contains(t.SecretNames(), name) {
+			return fmt.Errorf("%w by task %s", ErrSecretInUse, t.ID)
+		}
+	}
+	if err := m.Secrets.Delete(name); err != nil {
+		return err
+	}
+	log.Printf("[Manager] Removed secret %s\n", name)
+	return nil
+}
@@ -0,0 +1,197 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/service"
+	"kjarmicki.github.com/cube/task"
+)
+
+type ServiceStatus struct {
+	Service         *service.Service
+	LiveReplicas    int // running or about to run
+	RunningReplicas int
+}
+
+type ScaleRequest struct {
+	Replicas int
+}
+
+type UpdateRequest struct {
+	Template task.Task
+	Strategy *service.UpdateStrategy // keeps the current strategy when omitted
+}
+
+func (m *Manager) serviceStatus(s *service.Service) ServiceStatus {
+	status := ServiceStatus{Service: s}
+	for _, t := range m.serviceTasks(s.ID) {
+		status.LiveReplicas++
+		if t.State == task.Running {
+			status.RunningReplicas++
+		}
+	}
+	return status
+}
+
+func (a *Api) CreateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	s := service.Service{}
+	if err := d.Decode(&s); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	created, err := a.Manager.AddService(s)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid service: %v", err))
+		return
+	}
+
+	log.Printf("Added service %s\n", created.ID)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(created)
+}
+
+func (a *Api) GetServicesHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := []ServiceStatus{}
+	for _, s := range a.Manager.GetServices() {
+		statuses = append(statuses, a.Manager.serviceStatus(s))
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(statuses)
+}
+
+func (a *Api) GetServiceHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.lookupService(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.serviceStatus(s))
+}
+
+func (a *Api) ScaleServiceHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.lookupService(w, r)
+	if !ok {
+		return
+	}
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := ScaleRequest{}
+	if err := d.Decode(&req); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	scaled, err := a.Manager.ScaleService(s.ID, req.Replicas)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.serviceStatus(scaled))
+}
+
+func (a *Api) DeleteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.lookupService(w, r)
+	if !ok {
+		return
+	}
+	if err := a.Manager.RemoveService(s.ID); err != nil {
+		writeError(w, 404, err.Error())
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (a *Api) UpdateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.lookupService(w, r)
+	if !ok {
+		return
+	}
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := UpdateRequest{}
+	if err := d.Decode(&req); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	updated, err := a.Manager.UpdateService(s.ID, req.Template, req.Strategy)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.serviceStatus(updated))
+}
+
+func (a *Api) RollbackServiceHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.lookupService(w, r)
+	if !ok {
+		return
+	}
+	rolledBack, err := a.Manager.RollbackService(s.ID)
+	if err != nil {
+		writeError(w, 409, err.Error())
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.serviceStatus(rolledBack))
+}
+
+func (a *Api) ResumeServiceHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.lookupService(w, r)
+	if !ok {
+		return
+	}
+	resumed, err := a.Manager.ResumeService(s.ID)
+	if err != nil {
+		writeError(w, 409, err.Error())
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.serviceStatus(resumed))
+}
+
+func (a *Api) GetRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.lookupService(w, r)
+	if !ok {
+		return
+	}
+	revisions, _ := a.Manager.GetRevisions(s.ID)
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(revisions)
+}
+
+func (a *Api) lookupService(w http.ResponseWriter, r *http.Request) (*service.Service, bool) {
+	sID, err := uuid.Parse(chi.URLParam(r, "serviceID"))
+	if err != nil {
+		writeError(w, 400, "ServiceID passed in the request looks invalid")
+		return nil, false
+	}
+	s, ok := a.Manager.ServiceDb[sID]
+	if !ok {
+		writeError(w, 404, fmt.Sprintf("Service %s not found", sID))
+		return nil, false
+	}
+	return s, true
+}
@@ -0,0 +1,359 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/service"
+	"kjarmicki.github.com/cube/task"
+)
+
+// number of template revisions kept for each service
+const revisionHistoryLimit = 10
+
+func (m *Manager) AddService(s service.Service) (*service.Service, error) {
+	if s.Strategy == (service.UpdateStrategy{}) {
+		s.Strategy = service.DefaultStrategy
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	for _, existing := range m.ServiceDb {
+		if existing.Name == s.Name {
+			return nil, fmt.Errorf("service %s already exists", s.Name)
+		}
+	}
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	s.CreatedAt = time.Now().UTC()
+	s.Revision = 1
+	s.StableRevision = 1
+	s.Update = service.UpdateStatus{}
+	m.ServiceDb[s.ID] = &s
+	m.recordRevision(&s)
+	log.Printf("[Manager] Added service %s (%s) with %d replicas\n", s.Name, s.ID, s.Replicas)
+	return &s, nil
+}
+
+func (m *Manager) GetServices() []*service.Service {
+	services := make([]*service.Service, 0, len(m.ServiceDb))
+	for _, s := range m.ServiceDb {
+		services = append(services, s)
+	}
+	return services
+}
+
+func (m *Manager) ScaleService(id uuid.UUID, replicas int) (*service.Service, error) {
+	s, ok := m.ServiceDb[id]
+	if !ok {
+		return nil, fmt.Errorf("service %s not found", id)
+	}
+	if replicas < 0 {
+		return nil, fmt.Errorf("replicas can't be negative, got %d", replicas)
+	}
+	s.Replicas = replicas
+	log.Printf("[Manager] Scaled service %s to %d replicas\n", s.Name, replicas)
+	return s, nil
+}
+
+// UpdateService stores the template as a new revision and starts rolling it out
+func (m *Manager) UpdateService(id uuid.UUID, template task.Task, strategy *service.UpdateStrategy) (*service.Service, error) {
+	s, ok := m.ServiceDb[id]
+	if !ok {
+		return nil, fmt.Errorf("service %s not found", id)
+	}
+	if template.Image == "" {
+		return nil, errors.New("task template needs an image")
+	}
+	if err := template.Validate(); err != nil {
+		return nil, err
+	}
+	if strategy != nil {
+		if err := strategy.Validate(); err != nil {
+			return nil, err
+		}
+		s.Strategy = *strategy
+	}
+	m.startRollout(s, template, "template updated")
+	return s, nil
+}
+
+// RollbackService rolls out the last stable template again. When nothing is
+// being rolled out, the revision preceding the current one is used instead.
+func (m *Manager) RollbackService(id uuid.UUID) (*service.Service, error) {
+	s, ok := m.ServiceDb[id]
+	if !ok {
+		return nil, fmt.Errorf("service %s not found", id)
+	}
+	target := s.StableRevision
+	if target == s.Revision {
+		target = 0
+		for _, r := range m.Revisions[s.ID] {
+			if r.Number < s.Revision {
+				target = r.Number
+			}
+		}
+	}
+	r, ok := m.findRevision(s.ID, target)
+	if !ok {
+		return nil, fmt.Errorf("service %s has no revision to roll back to", s.Name)
+	}
+	m.startRollout(s, r.Template, fmt.Sprintf("rollback to revision %d", r.Number))
+	return s, nil
+}
+
+func (m *Manager) ResumeService(id uuid.UUID) (*service.Service, error) {
+	s, ok := m.ServiceDb[id]
+	if !ok {
+		return nil, fmt.Errorf("service %s not found", id)
+	}
+	if s.Update.State != service.UpdatePaused {
+		return nil, fmt.Errorf("update of service %s is not paused", s.Name)
+	}
+	s.Update.State = service.UpdateRunning
+	s.Update.Message = "resumed"
+	log.Printf("[Manager] Resumed update of service %s\n", s.Name)
+	return s, nil
+}
+
+func (m *Manager) GetRevisions(id uuid.UUID) ([]service.Revision, bool) {
+	if _, ok := m.ServiceDb[id]; !ok {
+		return nil, false
+	}
+	return m.Revisions[id], true
+}
+
+func (m *Manager) startRollout(s *service.Service, template task.Task, reason string) {
+	s.Template = template
+	s.Revision++
+	s.Update = service.UpdateStatus{
+		State:     service.UpdateRunning,
+		Message:   reason,
+		StartedAt: time.Now().UTC(),
+	}
+	m.recordRevision(s)
+	log.Printf("[Manager] Rolling out revision %d of service %s: %s\n", s.Revision, s.Name, reason)
+}
+
+func (m *Manager) recordRevision(s *service.Service) {
+	r := s.CurrentRevision()
+	r.CreatedAt = time.Now().UTC()
+	revisions := append(m.Revisions[s.ID], r)
+	if len(revisions) > revisionHistoryLimit {
+		revisions = revisions[len(revisions)-revisionHistoryLimit:]
+	}
+	m.Revisions[s.ID] = revisions
+}
+
+func (m *Manager) findRevision(serviceID uuid.UUID, number int) (service.Revision, bool) {
+	for _, r := range m.Revisions[serviceID] {
+		if r.Number == number {
+			return r, true
+		}
+	}
+	return service.Revision{}, false
+}
+
+// RemoveService forgets the service and stops all of its tasks
+func (m *Manager) RemoveService(id uuid.UUID) error {
+	s, ok := m.ServiceDb[id]
+	if !ok {
+		return fmt.Errorf("service %s not found", id)
+	}
+	delete(m.ServiceDb, id)
+	delete(m.Revisions, id)
+	for _, t := range m.serviceTasks(s.ID) {
+		m.stopTask(t, fmt.Sprintf("service %s removed", s.Name))
+	}
+	log.Printf("[Manager] Removed service %s\n", s.Name)
+	return nil
+}
+
+// replicas of a service that are running or about to run, oldest first
+func (m *Manager) serviceTasks(serviceID uuid.UUID) []*task.Task {
+	var tasks []*task.Task
+	for _, t := range m.TaskDb {
+		if t.ServiceID == serviceID && m.isTaskLive(t) {
+			tasks = append(tasks, t)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].StartTime.Equal(tasks[j].StartTime) {
+			return tasks[i].StartTime.Before(tasks[j].StartTime)
+		}
+		return tasks[i].ID.String() < tasks[j].ID.String()
+	})
+	return tasks
+}
+
+// tells whether a task is running or is going to run without anyone's intervention
+func (m *Manager) isTaskLive(t *task.Task) bool {
+	if m.stopping[t.ID] {
+		return false
+	}
+	switch t.State {
+	case task.Pending, task.Scheduled, task.Running:
+		return !m.isWorkerLost(m.TaskWorkerMap[t.ID])
+	case task.Failed:
+		// health checks will restart it on the same worker
+		return t.RestartCount < 3 && !m.isWorkerLost(m.TaskWorkerMap[t.ID])
+	default:
+		return false
+	}
+}
+
+func (m *Manager) isTaskHealthy(t *task.Task, waitForHealthy bool) bool {
+	if t.State != task.Running {
+		return false
+	}
+	if !waitForHealthy || t.HealthCheck == "" {
+		return true
+	}
+	// the health check itself is up to doHealthChecks, which calls it without the lock
+	return m.healthy[t.ID]
+}
+
+func (m *Manager) stopTask(t *task.Task, reason string) {
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Completed,
+		Timestamp: time.Now(),
+	}
+	taskCopy := *t
+	taskCopy.State = task.Completed
+	te.Task = taskCopy
+	m.AddTask(te)
+	log.Printf("[Manager] Stopping task %s: %s\n", t.ID, reason)
+}
+
+func (m *Manager) startTask(t task.Task) {
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: time.Now(),
+		Task:      t,
+	}
+	m.AddTask(te)
+}
+
+func (m *Manager) ReconcileServices() {
+	for {
+		log.Println("[Manager] Reconciling services")
+		m.locked(m.reconcileServices)
+		log.Println("[Manager] Services reconciled, sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (m *Manager) reconcileServices() {
+	for _, s := range m.GetServices() {
+		switch s.Update.State {
+		case service.UpdateRunning:
+			m.rollService(s)
+		case service.UpdatePaused:
+			// keep the replica count, but with the template known to work
+			stable, ok := m.findRevision(s.ID, s.StableRevision)
+			if !ok {
+				stable = s.CurrentRevision()
+			}
+			m.scaleService(s, stable)
+		default:
+			m.scaleService(s, s.CurrentRevision())
+		}
+	}
+}
+
+// converges the number of live replicas of a service onto the desired one
+func (m *Manager) scaleService(s *service.Service, r service.Revision) {
+	tasks := m.serviceTasks(s.ID)
+	switch {
+	case len(tasks) < s.Replicas:
+		missing := s.Replicas - len(tasks)
+		log.Printf("[Manager] Service %s has %d of %d replicas, starting %d\n", s.Name, len(tasks), s.Replicas, missing)
+		for i := 0; i < missing; i++ {
+			m.startTask(s.NewTaskFromRevision(r))
+		}
+	case len(tasks) > s.Replicas:
+		extra := len(tasks) - s.Replicas
+		log.Printf("[Manager] Service %s has %d of %d replicas, stopping %d\n", s.Name, len(tasks), s.Replicas, extra)
+		// the newest replicas go first
+		for _, t := range tasks[len(tasks)-extra:] {
+			m.stopTask(t, fmt.Sprintf("service %s scaled down", s.Name))
+		}
+	}
+}
+
+// moves a service one step closer to running only the current revision,
+// staying within the surge and unavailability bounds of its strategy
+func (m *Manager) rollService(s *service.Service) {
+	failed := 0
+	for _, t := range m.TaskDb {
+		if t.ServiceID == s.ID && t.ServiceRevision == s.Revision && (t.State == task.Failed || t.RestartCount > 0) {
+			failed++
+		}
+	}
+	if failed > s.Strategy.FailureThreshold {
+		s.Update.State = service.UpdatePaused
+		s.Update.Message = fmt.Sprintf("%d replicas of revision %d failed", failed, s.Revision)
+		log.Printf("[Manager] Paused update of service %s: %s\n", s.Name, s.Update.Message)
+		return
+	}
+
+	var old, current []*task.Task
+	available, healthy := 0, 0
+	for _, t := range m.serviceTasks(s.ID) {
+		if t.ServiceRevision == s.Revision {
+			current = append(current, t)
+			if m.isTaskHealthy(t, s.Strategy.WaitForHealthy) {
+				healthy++
+				available++
+			}
+		} else {
+			old = append(old, t)
+			if t.State == task.Running {
+				available++
+			}
+		}
+	}
+
+	if len(old) == 0 && len(current) == s.Replicas && healthy == s.Replicas {
+		s.StableRevision = s.Revision
+		s.Update.State = service.UpdateCompleted
+		s.Update.Message = fmt.Sprintf("revision %d rolled out", s.Revision)
+		s.Update.FinishedAt = time.Now().UTC()
+		log.Printf("[Manager] Finished update of service %s to revision %d\n", s.Name, s.Revision)
+		return
+	}
+
+	toStart := min(s.Replicas+s.Strategy.MaxSurge-len(old)-len(current), s.Replicas-len(current))
+	for i := 0; i < toStart; i++ {
+		m.startTask(s.NewTask())
+	}
+	if len(current) > s.Replicas {
+		// the service was scaled down in the middle of an update
+		for _, t := range current[s.Replicas:] {
+			m.stopTask(t, fmt.Sprintf("service %s scaled down", s.Name))
+		}
+	}
+
+	// replicas that aren't running can go right away, running ones only
+	// as long as enough of the service stays available
+	toStop := available - (s.Replicas - s.Strategy.MaxUnavailable)
+	sort.SliceStable(old, func(i, j int) bool {
+		return old[i].State != task.Running && old[j].State == task.Running
+	})
+	for _, t := range old {
+		if t.State == task.Running {
+			if toStop <= 0 {
+				break
+			}
+			toStop--
+		}
+		m.stopTask(t, fmt.Sprintf("replaced by revision %d of service %s", s.Revision, s.Name))
+	}
+}
@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"kjarmicki.github.com/cube/volume"
+)
+
+func (a *Api) CreateVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	v := volume.Volume{}
+	if err := d.Decode(&v); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	created, err := a.Manager.AddVolume(v)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error creating volume: %v", err))
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(created)
+}
+
+func (a *Api) GetVolumesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.GetVolumes())
+}
+
+func (a *Api) GetVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	v, ok := a.lookupVolume(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (a *Api) DeleteVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	err := a.Manager.RemoveVolume(name)
+	if errors.Is(err, ErrVolumeNotFound) {
+		writeError(w, 404, fmt.Sprintf("Volume %s not found", name))
+		return
+	}
+	if err != nil {
+		writeError(w, 409, err.Error())
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (a *Api) lookupVolume(w http.ResponseWriter, r *http.Request) (*volume.Volume, bool) {
+	name := chi.URLParam(r, "name")
+	v, ok := a.Manager.VolumeDb[name]
+	if !ok {
+		writeError(w, 404, fmt.Sprintf("Volume %s not found", name))
+		return nil, false
+	}
+	return v, true
+}
@@ -0,0 +1,226 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/task"
+	"kjarmicki.github.com/cube/volume"
+	"kjarmicki.github.com/cube/worker"
+)
+
+var ErrVolumeNotFound = errors.New("volume not found")
+
+// AddVolume creates a volume on the given worker, or on the next one in line when none is given.
+// It takes the lock itself, the worker is called without it.
+func (m *Manager) AddVolume(v volume.Volume) (*volume.Volume, error) {
+	if err := volume.ValidateName(v.Name); err != nil {
+		return nil, err
+	}
+	var err error
+	m.locked(func() {
+		if existing, ok := m.VolumeDb[v.Name]; ok {
+			err = fmt.Errorf("volume %s already exists on node %s", v.Name, existing.Node)
+			return
+		}
+		if v.Node == "" {
+			v.Node = m.SelectWorker()
+		} else if !slices.Contains(m.Workers, v.Node) {
+			err = fmt.Errorf("unknown node %s", v.Node)
+			return
+		}
+		if m.isWorkerLost(v.Node) {
+			err = fmt.Errorf("node %s is lost", v.Node)
+			return
+		}
+		// hold the name while the worker creates it
+		m.VolumeDb[v.Name] = &volume.Volume{Name: v.Name, Node: v.Node}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := createVolume(v)
+	m.locked(func() {
+		if err != nil {
+			if held, ok := m.VolumeDb[v.Name]; ok && held.Node == v.Node && !m.isVolumeClaimed(v.Name) {
+				delete(m.VolumeDb, v.Name)
+			}
+			return
+		}
+		m.VolumeDb[created.Name] = created
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[Manager] Added volume %s on %s\n", created.Name, created.Node)
+	return created, nil
+}
+
+func createVolume(v volume.Volume) (*volume.Volume, error) {
+	data, err := json.Marshal(volume.Volume{Name: v.Name})
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("http://%s/volumes", v.Node)
+	resp, err := workerClient.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", v.Node, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, workerError(resp)
+	}
+	created := volume.Volume{}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, err
+	}
+	created.Node = v.Node
+	return &created, nil
+}
+
+func (m *Manager) GetVolumes() []*volume.Volume {
+	volumes := make([]*volume.Volume, 0, len(m.VolumeDb))
+	for _, v := range m.VolumeDb {
+		volumes = append(volumes, v)
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+	return volumes
+}
+
+// RemoveVolume deletes the volume and its data, unless a task still claims it.
+// It takes the lock itself, the worker is called without it.
+func (m *Manager) RemoveVolume(name string) error {
+	var node string
+	var err error
+	m.locked(func() {
+		v, ok := m.VolumeDb[name]
+		if !ok {
+			err = fmt.Errorf("%w: %s", ErrVolumeNotFound, name)
+			return
+		}
+		if m.isVolumeClaimed(name) {
+			err = fmt.Errorf("volume %s is claimed by a task", name)
+			return
+		}
+		node = v.Node
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/volumes/%s", node, name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := workerClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", node, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return workerError(resp)
+	}
+	m.locked(func() {
+		// a task may have claimed it again in the meantime, the next sync drops it otherwise
+		if v, ok := m.VolumeDb[name]; ok && v.Node == node && !m.isVolumeClaimed(name) {
+			delete(m.VolumeDb, name)
+		}
+	})
+	log.Printf("[Manager] Removed volume %s from %s\n", name, node)
+	return nil
+}
+
+func workerError(resp *http.Response) error {
+	e := worker.ErrResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		return fmt.Errorf("worker responded with %d", resp.StatusCode)
+	}
+	return fmt.Errorf("worker responded with %d: %s", resp.StatusCode, e.Message)
+}
+
+func fetchVolumes(w string) ([]volume.Volume, error) {
+	resp, err := workerClient.Get(fmt.Sprintf("http://%s/volumes", w))
+	if err != nil {
+		log.Printf("[Manager] Error while connecting to %s for volumes\n", w)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var reported []volume.Volume
+	if err := json.NewDecoder(resp.Body).Decode(&reported); err != nil {
+		log.Printf("[Manager] Error while decoding volumes of %s: %v\n", w, err)
+		return nil, err
+	}
+	return reported, nil
+}
+
+// syncVolumes replaces what's known about the volumes of a worker with what it reports
+func (m *Manager) syncVolumes(w string, reported []volume.Volume) {
+	present := make(map[string]bool)
+	for i := range reported {
+		v := &reported[i]
+		v.Node = w
+		present[v.Name] = true
+		if existing, ok := m.VolumeDb[v.Name]; ok && existing.Node != w {
+			log.Printf("[Manager] Volume %s is on both %s and %s, keeping the one on %s\n", v.Name, existing.Node, w, existing.Node)
+			continue
+		}
+		m.VolumeDb[v.Name] = v
+	}
+	for name, v := range m.VolumeDb {
+		if v.Node == w && !present[name] && !m.isVolumeClaimed(name) {
+			delete(m.VolumeDb, name)
+		}
+	}
+}
+
+func (m *Manager) isVolumeClaimed(name string) bool {
+	for _, t := range m.TaskDb {
+		if m.isTaskLive(t) && slices.Contains(t.LocalVolumes(), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// claimVolumes records the volumes a task is about to create on a worker,
+// so that other tasks claiming them follow it there
+func (m *Manager) claimVolumes(w string, t task.Task) {
+	for _, name := range t.LocalVolumes() {
+		if _, ok := m.VolumeDb[name]; !ok {
+			m.VolumeDb[name] = &volume.Volume{Name: name, Node: w}
+		}
+	}
+}
+
+// pinnedWorker finds the worker holding the volumes claimed by the task, or the
+// one running its group. Volumes nobody has yet are created wherever the task lands.
+func (m *Manager) pinnedWorker(t task.Task) (string, string) {
+	if t.InfraTaskID != uuid.Nil {
+		return m.groupWorker(t)
+	}
+	pinned := ""
+	for _, name := range t.LocalVolumes() {
+		v, ok := m.VolumeDb[name]
+		if !ok {
+			continue
+		}
+		if pinned != "" && pinned != v.Node {
+			return "", fmt.Sprintf("claims volumes on different nodes (%s and %s)", pinned, v.Node)
+		}
+		pinned = v.Node
+	}
+	if pinned != "" && m.isWorkerLost(pinned) {
+		return "", fmt.Sprintf("node %s holding its volumes is lost", pinned)
+	}
+	return pinned, ""
+}
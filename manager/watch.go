@@ -0,0 +1,114 @@
+package manager
+
+import (
+	"errors"
+	"sync"
+
+	"kjarmicki.github.com/cube/task"
+)
+
+const (
+	WatchCreated = "created"
+	WatchUpdated = "updated"
+	WatchDeleted = "deleted" // stop of the task was accepted by its worker
+
+	watchHistorySize = 1024 // events kept around for watchers resuming from a version
+	watchBufferSize  = 64   // events a single watcher may lag behind before it's dropped
+)
+
+var ErrWatchExpired = errors.New("requested resource version is too old, list tasks and watch again")
+
+type WatchEvent struct {
+	Type            string
+	ResourceVersion uint64
+	Task            task.Task
+}
+
+type watchHub struct {
+	mu       sync.Mutex
+	version  uint64
+	history  []WatchEvent
+	watchers map[chan WatchEvent]struct{}
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{watchers: make(map[chan WatchEvent]struct{})}
+}
+
+func (h *watchHub) publish(eventType string, t task.Task) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.version++
+	e := WatchEvent{Type: eventType, ResourceVersion: h.version, Task: t}
+	h.history = append(h.history, e)
+	if len(h.history) > watchHistorySize {
+		h.history = h.history[len(h.history)-watchHistorySize:]
+	}
+	for ch := range h.watchers {
+		select {
+		case ch <- e:
+		default:
+			// slow watcher, cut it off; it can resume from the last version it saw
+			delete(h.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns events newer than the given version that are still in
+// history, followed by a channel delivering everything published afterwards
+func (h *watchHub) subscribe(since uint64) ([]WatchEvent, chan WatchEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if since > h.version {
+		since = h.version
+	}
+	if len(h.history) > 0 && since+1 < h.history[0].ResourceVersion {
+		return nil, nil, ErrWatchExpired
+	}
+	var backlog []WatchEvent
+	for _, e := range h.history {
+		if e.ResourceVersion > since {
+			backlog = append(backlog, e)
+		}
+	}
+	ch := make(chan WatchEvent, watchBufferSize)
+	h.watchers[ch] = struct{}{}
+	return backlog, ch, nil
+}
+
+func (h *watchHub) unsubscribe(ch chan WatchEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.watchers[ch]; ok {
+		delete(h.watchers, ch)
+		close(ch)
+	}
+}
+
+func (h *watchHub) currentVersion() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.version
+}
+
+func (m *Manager) publishTask(eventType string, t *task.Task) {
+	m.watch.publish(eventType, *t)
+}
+
+// Watch streams task changes. Passing 0 starts from the current version,
+// so only changes made from now on are delivered.
+func (m *Manager) Watch(since uint64) ([]WatchEvent, chan WatchEvent, error) {
+	if since == 0 {
+		since = m.watch.currentVersion()
+	}
+	return m.watch.subscribe(since)
+}
+
+func (m *Manager) StopWatching(ch chan WatchEvent) {
+	m.watch.unsubscribe(ch)
+}
+
+func (m *Manager) ResourceVersion() uint64 {
+	return m.watch.currentVersion()
+}
@@ -0,0 +1,90 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/workflow"
+)
+
+func (a *Api) CreateWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	wf := workflow.Workflow{}
+	if err := d.Decode(&wf); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	created, err := a.Manager.AddWorkflow(wf)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid workflow: %v", err))
+		return
+	}
+
+	log.Printf("Added workflow %s\n", created.ID)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(created)
+}
+
+func (a *Api) GetWorkflowsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(a.Manager.GetWorkflows())
+}
+
+func (a *Api) GetWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	wf, ok := a.lookupWorkflow(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(wf)
+}
+
+func (a *Api) GetWorkflowGraphHandler(w http.ResponseWriter, r *http.Request) {
+	wf, ok := a.lookupWorkflow(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(wf.Graph())
+}
+
+func (a *Api) CancelWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	wf, ok := a.lookupWorkflow(w, r)
+	if !ok {
+		return
+	}
+	if workflow.IsFinished(wf.State) {
+		writeError(w, 409, fmt.Sprintf("Workflow %s has already %s", wf.ID, wf.State))
+		return
+	}
+	if err := a.Manager.CancelWorkflow(wf.ID); err != nil {
+		writeError(w, 404, err.Error())
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (a *Api) lookupWorkflow(w http.ResponseWriter, r *http.Request) (*workflow.Workflow, bool) {
+	wID, err := uuid.Parse(chi.URLParam(r, "workflowID"))
+	if err != nil {
+		writeError(w, 400, "WorkflowID passed in the request looks invalid")
+		return nil, false
+	}
+	wf, ok := a.Manager.WorkflowDb[wID]
+	if !ok {
+		writeError(w, 404, fmt.Sprintf("Workflow %s not found", wID))
+		return nil, false
+	}
+	return wf, true
+}
@@ -0,0 +1,174 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/task"
+	"kjarmicki.github.com/cube/workflow"
+)
+
+// AddWorkflow registers every step as a pending task; steps are sent to
+// workers only once everything they depend on has succeeded
+func (m *Manager) AddWorkflow(w workflow.Workflow) (*workflow.Workflow, error) {
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	w.CreatedAt = time.Now().UTC()
+	w.Prepare()
+	for _, s := range w.Steps {
+		t := s.Task
+		m.TaskDb[t.ID] = &t
+		m.publishTask(WatchCreated, &t)
+		m.recordTimeline(t.ID, t.State, task.TimelineSubmitted, "", fmt.Sprintf("step %s of workflow %s", s.Name, w.Name))
+	}
+	m.WorkflowDb[w.ID] = &w
+	log.Printf("[Manager] Added workflow %s (%s) with %d steps\n", w.Name, w.ID, len(w.Steps))
+	m.reconcileWorkflow(&w)
+	return &w, nil
+}
+
+func (m *Manager) GetWorkflows() []*workflow.Workflow {
+	workflows := make([]*workflow.Workflow, 0, len(m.WorkflowDb))
+	for _, w := range m.WorkflowDb {
+		workflows = append(workflows, w)
+	}
+	return workflows
+}
+
+// CancelWorkflow stops running steps and skips the ones that haven't started
+func (m *Manager) CancelWorkflow(id uuid.UUID) error {
+	w, ok := m.WorkflowDb[id]
+	if !ok {
+		return fmt.Errorf("workflow %s not found", id)
+	}
+	for i := range w.Steps {
+		s := &w.Steps[i]
+		switch s.State {
+		case workflow.Waiting:
+			m.settleStep(w, s, workflow.Skipped, "workflow cancelled")
+		case workflow.Running:
+			if t, ok := m.TaskDb[s.Task.ID]; ok {
+				m.stopTask(t, fmt.Sprintf("workflow %s cancelled", w.Name))
+			}
+			s.State = workflow.Failed
+			s.Reason = "workflow cancelled"
+		}
+	}
+	m.finishWorkflow(w)
+	log.Printf("[Manager] Cancelled workflow %s\n", w.Name)
+	return nil
+}
+
+func (m *Manager) ReconcileWorkflows() {
+	for {
+		log.Println("[Manager] Reconciling workflows")
+		m.locked(m.reconcileWorkflows)
+		log.Println("[Manager] Workflows reconciled, sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (m *Manager) reconcileWorkflows() {
+	for _, w := range m.GetWorkflows() {
+		if w.State == workflow.Running {
+			m.reconcileWorkflow(w)
+		}
+	}
+}
+
+// walks the steps in dependency order, so that a single pass both notices
+// a finished step and releases or skips whatever depends on it
+func (m *Manager) reconcileWorkflow(w *workflow.Workflow) {
+	order, err := w.Order()
+	if err != nil {
+		log.Printf("[Manager] Workflow %s is broken: %v\n", w.Name, err)
+		return
+	}
+	for _, name := range order {
+		s := w.Step(name)
+		t, ok := m.TaskDb[s.Task.ID]
+		if !ok || workflow.IsFinished(s.State) {
+			continue
+		}
+
+		if s.State == workflow.Running {
+			switch {
+			case t.State == task.Completed && t.ExitCode == 0:
+				s.State = workflow.Succeeded
+			case t.State == task.Completed || t.State == task.Failed:
+				s.State = workflow.Failed
+				s.Reason = fmt.Sprintf("task %s exited with code %d", t.ID, t.ExitCode)
+			}
+			continue
+		}
+
+		released := true
+		for _, d := range s.DependsOn {
+			parent := w.Step(d)
+			switch parent.State {
+			case workflow.Succeeded:
+			case workflow.Failed, workflow.Skipped:
+				reason := fmt.Sprintf("step %s did not succeed", parent.Name)
+				if w.OnFailure == workflow.SkipDownstream {
+					m.settleStep(w, s, workflow.Skipped, reason)
+				} else {
+					m.settleStep(w, s, workflow.Failed, reason)
+				}
+				released = false
+			default:
+				released = false
+			}
+			if workflow.IsFinished(s.State) {
+				break
+			}
+		}
+		if released {
+			log.Printf("[Manager] Releasing step %s of workflow %s\n", s.Name, w.Name)
+			s.State = workflow.Running
+			m.startTask(*t)
+		}
+	}
+
+	for _, s := range w.Steps {
+		if !workflow.IsFinished(s.State) {
+			return
+		}
+	}
+	m.finishWorkflow(w)
+}
+
+// settles a step that will never run, along with its task
+func (m *Manager) settleStep(w *workflow.Workflow, s *workflow.Step, state string, reason string) {
+	s.State = state
+	s.Reason = reason
+	t, ok := m.TaskDb[s.Task.ID]
+	if !ok {
+		return
+	}
+	if state == workflow.Skipped {
+		t.State = task.Skipped
+	} else {
+		t.State = task.Failed
+	}
+	t.FinishTime = time.Now().UTC()
+	m.recordTimeline(t.ID, t.State, task.TimelineKindForState(t.State), "", reason)
+	m.publishTask(WatchUpdated, t)
+	log.Printf("[Manager] Step %s of workflow %s %s: %s\n", s.Name, w.Name, state, reason)
+}
+
+func (m *Manager) finishWorkflow(w *workflow.Workflow) {
+	w.State = workflow.Succeeded
+	for _, s := range w.Steps {
+		if s.State == workflow.Failed {
+			w.State = workflow.Failed
+		}
+	}
+	w.Finished = time.Now().UTC()
+	log.Printf("[Manager] Workflow %s has %s\n", w.Name, w.State)
+}
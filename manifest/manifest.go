@@ -0,0 +1,282 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"kjarmicki.github.com/cube/job"
+	"kjarmicki.github.com/cube/service"
+	"kjarmicki.github.com/cube/task"
+)
+
+// resources created by apply carry this label, so that prune never touches anything else
+const (
+	ManagedLabel = "cube/managed-by"
+	ManagedValue = "apply"
+)
+
+// standalone tasks keep the name they were declared with in this label, replacements
+// made when their config changes get a name of their own but carry the label over
+const NameLabel = "cube/manifest-name"
+
+// kinds of resources in a manifest
+const (
+	KindTask    = "task"
+	KindService = "service"
+	KindJob     = "job"
+)
+
+// actions of a plan
+const (
+	Create    = "create"
+	Update    = "update"
+	Replace   = "replace" // stop or remove the existing resource and create it anew
+	Delete    = "delete"
+	Unchanged = "unchanged"
+)
+
+// Manifest declares the desired state of resources, identified by their names
+type Manifest struct {
+	Tasks    []task.Task
+	Services []service.Service
+	Jobs     []job.Job
+}
+
+type Action struct {
+	Kind    string
+	Name    string
+	Action  string
+	Changes []string // fields that differ from the current state
+	Error   string   `json:",omitempty"` // why carrying out the action failed
+}
+
+type Plan struct {
+	Actions []Action
+	Applied bool // every action was carried out
+}
+
+// Err returns the first action that failed as an error
+func (p Plan) Err() error {
+	for _, a := range p.Actions {
+		if a.Error != "" {
+			return fmt.Errorf("%s %s/%s: %s", a.Action, a.Kind, a.Name, a.Error)
+		}
+	}
+	return nil
+}
+
+// Parse reads a manifest in either JSON or YAML. YAML is converted to JSON
+// first, so both formats share field names with the rest of the API.
+func Parse(data []byte) (Manifest, error) {
+	mf := Manifest{}
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] != '{' {
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return mf, fmt.Errorf("invalid YAML: %w", err)
+		}
+		converted, err := json.Marshal(jsonCompatible(doc))
+		if err != nil {
+			return mf, fmt.Errorf("invalid YAML: %w", err)
+		}
+		data = converted
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&mf); err != nil {
+		return mf, err
+	}
+	return mf, mf.Validate()
+}
+
+// yaml decodes nested mappings as map[string]interface{} which encoding/json can handle,
+// but keys which aren't strings (e.g. numbers) have to be turned into strings
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			v[k] = jsonCompatible(val)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = jsonCompatible(val)
+		}
+		return m
+	case []interface{}:
+		for i, val := range v {
+			v[i] = jsonCompatible(val)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func (mf *Manifest) Validate() error {
+	seen := make(map[string]bool)
+	check := func(kind, name string) error {
+		if name == "" {
+			return fmt.Errorf("every %s in the manifest needs a name", kind)
+		}
+		key := kind + "/" + name
+		if seen[key] {
+			return fmt.Errorf("%s %s is declared twice", kind, name)
+		}
+		seen[key] = true
+		return nil
+	}
+	for _, t := range mf.Tasks {
+		if err := check(KindTask, t.Name); err != nil {
+			return err
+		}
+		if t.Image == "" {
+			return fmt.Errorf("task %s needs an image", t.Name)
+		}
+		if err := t.Validate(); err != nil {
+			return fmt.Errorf("task %s: %w", t.Name, err)
+		}
+	}
+	for _, s := range mf.Services {
+		if err := check(KindService, s.Name); err != nil {
+			return err
+		}
+		if s.Strategy == (service.UpdateStrategy{}) {
+			s.Strategy = service.DefaultStrategy
+		}
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, j := range mf.Jobs {
+		if err := check(KindJob, j.Name); err != nil {
+			return err
+		}
+		if err := j.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(seen) == 0 {
+		return errors.New("manifest declares no resources")
+	}
+	return nil
+}
+
+// Managed marks labels as belonging to apply
+func Managed(labels map[string]string) map[string]string {
+	managed := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		managed[k] = v
+	}
+	managed[ManagedLabel] = ManagedValue
+	return managed
+}
+
+func IsManaged(labels map[string]string) bool {
+	return labels[ManagedLabel] == ManagedValue
+}
+
+// TaskSpec strips a task down to what can be declared in a manifest
+func TaskSpec(t task.Task) task.Task {
+	spec := TemplateSpec(t)
+	spec.Name = DeclaredName(t)
+	spec.Labels = Managed(t.Labels)
+	spec.Labels[NameLabel] = spec.Name
+	return spec
+}
+
+// DeclaredName is the name a task has in the manifest
+func DeclaredName(t task.Task) string {
+	if name, ok := t.Labels[NameLabel]; ok {
+		return name
+	}
+	return t.Name
+}
+
+// TemplateSpec is TaskSpec for task templates of services and jobs, which aren't labelled as managed
+func TemplateSpec(t task.Task) task.Task {
+	return task.Task{
+		Name:            t.Name,
+		Labels:          t.Labels,
+		Priority:        t.Priority,
+		Image:           t.Image,
+		Cpu:             t.Cpu,
+		CpuLimit:        t.CpuLimit,
+		Memory:          t.Memory,
+		Disk:            t.Disk,
+		Mounts:          t.Mounts,
+		Secrets:         t.Secrets,
+		Configs:         configSpecs(t.Configs),
+		ExposedPorts:    t.ExposedPorts,
+		PortBindings:    t.PortBindings,
+		RestartPolicy:   t.RestartPolicy,
+		PullPolicy:      t.PullPolicy,
+		InitContainers:  t.InitContainers,
+		PostStart:       t.PostStart,
+		StopSignal:      t.StopSignal,
+		StopGracePeriod: t.StopGracePeriod,
+		PreStop:         t.PreStop,
+		KeepContainer:   t.KeepContainer,
+		HealthCheck:     t.HealthCheck,
+	}
+}
+
+// pinned versions are up to the manager
+func configSpecs(refs []task.ConfigRef) []task.ConfigRef {
+	var specs []task.ConfigRef
+	for _, r := range refs {
+		r.Version = 0
+		specs = append(specs, r)
+	}
+	return specs
+}
+
+// Diff lists exported fields which differ between two values of the same struct type,
+// treating nil and empty maps or slices as equal
+func Diff(current, desired interface{}) []string {
+	a, b := reflect.ValueOf(current), reflect.ValueOf(desired)
+	var changes []string
+	for i := 0; i < a.NumField(); i++ {
+		f := a.Type().Field(i)
+		if !f.IsExported() || equal(a.Field(i), b.Field(i)) {
+			continue
+		}
+		changes = append(changes, f.Name)
+	}
+	return changes
+}
+
+func equal(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Map, reflect.Slice:
+		if a.Len() == 0 && b.Len() == 0 {
+			return true
+		}
+	case reflect.Struct:
+		if a.Type().Name() != "Time" && a.Type().PkgPath() != "github.com/google/uuid" {
+			return len(Diff(a.Interface(), b.Interface())) == 0
+		}
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+func (p Plan) String() string {
+	var sb strings.Builder
+	for _, a := range p.Actions {
+		fmt.Fprintf(&sb, "%-9s %s/%s", a.Action, a.Kind, a.Name)
+		if len(a.Changes) > 0 {
+			fmt.Fprintf(&sb, " (%s)", strings.Join(a.Changes, ", "))
+		}
+		if a.Error != "" {
+			fmt.Fprintf(&sb, " failed: %s", a.Error)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -4,10 +4,14 @@ type Node struct {
 	Name            string
 	Ip              string
 	Cores           int
-	Memory          int
+	CpuAllocated    float64
+	Memory          int // in bytes, like task memory; 0 until the worker reports its stats
 	MemoryAllocated int
+	Disk            int
+	DiskAllocated   int
 	Role            string
 	TaskCount       int
+	Images          []string // normalized names of the images cached on the node
 }
 
 func NewNode(name string, ip string, role string) *Node {
@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	dockerregistry "github.com/docker/docker/api/types/registry"
+)
+
+// Credential lets workers pull images from a private registry. The manager keeps
+// it, password included, encrypted in a store of its own, apart from secrets.
+type Credential struct {
+	Server    string // registry host, e.g. registry.example.com:5000 or docker.io
+	Username  string
+	Password  string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+func (c *Credential) Validate() error {
+	if c.Server == "" || c.Username == "" || c.Password == "" {
+		return errors.New("server, username and password are required")
+	}
+	c.Server = NormalizeServer(c.Server)
+	return nil
+}
+
+// Redacted is the credential without its password, as shown by the API
+func (c Credential) Redacted() Credential {
+	c.Password = ""
+	return c
+}
+
+// Encode turns the credential into the form docker expects when pulling
+func (c Credential) Encode() (string, error) {
+	return dockerregistry.EncodeAuthConfig(dockerregistry.AuthConfig{
+		Username:      c.Username,
+		Password:      c.Password,
+		ServerAddress: c.Server,
+	})
+}
+
+// StoreKey is where the credential for a server is kept in the registries store
+func StoreKey(server string) string {
+	return strings.ReplaceAll(server, ":", "_")
+}
+
+// docker hub goes by a few names
+func NormalizeServer(server string) string {
+	switch server {
+	case "index.docker.io", "registry-1.docker.io", "https://index.docker.io/v1/":
+		return "docker.io"
+	}
+	return server
+}
+
+// Server tells which registry an image is pulled from
+func Server(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("invalid image %q: %w", image, err)
+	}
+	return reference.Domain(named), nil
+}
@@ -0,0 +1,243 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("secret not found")
+	ErrExists   = errors.New("secret already exists")
+)
+
+// Secret describes a stored secret. Its value is never part of it,
+// so it's safe to hand out through the API.
+type Secret struct {
+	Name      string
+	Version   int // bumped on every update
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// what's kept in memory and on disk, the value only ever in its encrypted form
+type entry struct {
+	Secret
+	Ciphertext []byte
+}
+
+// Store keeps secrets encrypted with AES-GCM, optionally persisting them to a file
+type Store struct {
+	mu      sync.Mutex
+	aead    cipher.AEAD
+	path    string
+	entries map[string]*entry
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q, use letters, digits, '_', '.' and '-'", name)
+	}
+	return nil
+}
+
+// LoadKey reads a base64 encoded 32 byte key, e.g. one made with
+// head -c 32 /dev/urandom | base64
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("key in %s isn't valid base64: %w", path, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key in %s has %d bytes, expected 32", path, len(key))
+	}
+	return key, nil
+}
+
+// NewStore creates a store using the key, loading whatever was saved at path before.
+// With an empty path secrets are only kept in memory.
+func NewStore(key []byte, path string) (*Store, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{aead: aead, path: path, entries: make(map[string]*entry)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %w", path, err)
+	}
+	for _, e := range entries {
+		if _, err := s.decrypt(e); err != nil {
+			return nil, fmt.Errorf("can't decrypt secret %s, is it the right key? %w", e.Name, err)
+		}
+		s.entries[e.Name] = e
+	}
+	return s, nil
+}
+
+func (s *Store) Create(name string, value []byte) (Secret, error) {
+	if err := ValidateName(name); err != nil {
+		return Secret{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[name]; ok {
+		return Secret{}, ErrExists
+	}
+	now := time.Now().UTC()
+	e := &entry{Secret: Secret{Name: name, Version: 1, CreatedAt: now, UpdatedAt: now}}
+	if err := s.encrypt(e, value); err != nil {
+		return Secret{}, err
+	}
+	s.entries[name] = e
+	if err := s.save(); err != nil {
+		delete(s.entries, name)
+		return Secret{}, err
+	}
+	return e.Secret, nil
+}
+
+func (s *Store) Update(name string, value []byte) (Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[name]
+	if !ok {
+		return Secret{}, ErrNotFound
+	}
+	updated := &entry{Secret: e.Secret}
+	updated.Version++
+	updated.UpdatedAt = time.Now().UTC()
+	if err := s.encrypt(updated, value); err != nil {
+		return Secret{}, err
+	}
+	s.entries[name] = updated
+	if err := s.save(); err != nil {
+		s.entries[name] = e
+		return Secret{}, err
+	}
+	return updated.Secret, nil
+}
+
+func (s *Store) Get(name string) (Secret, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[name]
+	if !ok {
+		return Secret{}, false
+	}
+	return e.Secret, true
+}
+
+func (s *Store) List() []Secret {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets := make([]Secret, 0, len(s.entries))
+	for _, e := range s.entries {
+		secrets = append(secrets, e.Secret)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+	return secrets
+}
+
+// Reveal decrypts the value of a secret, which should only ever be passed on to a worker
+func (s *Store) Reveal(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.decrypt(e)
+}
+
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[name]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.entries, name)
+	if err := s.save(); err != nil {
+		s.entries[name] = e
+		return err
+	}
+	return nil
+}
+
+// the name is authenticated along with the value, so ciphertexts can't be swapped between secrets
+func (s *Store) encrypt(e *entry, value []byte) error {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	e.Ciphertext = s.aead.Seal(nonce, nonce, value, []byte(e.Name))
+	return nil
+}
+
+func (s *Store) decrypt(e *entry) ([]byte, error) {
+	n := s.aead.NonceSize()
+	if len(e.Ciphertext) < n {
+		return nil, errors.New("ciphertext too short")
+	}
+	return s.aead.Open(nil, e.Ciphertext[:n], e.Ciphertext[n:], []byte(e.Name))
+}
+
+// save writes all entries to a temporary file first, so a crash never leaves a partial file behind
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	entries := make([]*entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
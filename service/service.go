@@ -0,0 +1,113 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/task"
+)
+
+// states of a rolling update
+const (
+	UpdateRunning   = "updating"
+	UpdatePaused    = "paused"
+	UpdateCompleted = "completed"
+)
+
+// Service keeps a number of identical tasks running, each one created from Template
+type Service struct {
+	ID             uuid.UUID
+	Name           string
+	Replicas       int
+	Labels         map[string]string
+	Template       task.Task
+	Strategy       UpdateStrategy
+	Revision       int // revision of Template
+	StableRevision int // last revision that was fully rolled out
+	Update         UpdateStatus
+	CreatedAt      time.Time
+}
+
+// UpdateStrategy controls how replicas are replaced when the template changes
+type UpdateStrategy struct {
+	MaxSurge         int  // replicas allowed above the desired count during an update
+	MaxUnavailable   int  // replicas allowed to be missing during an update
+	WaitForHealthy   bool // new replicas count as available only once they pass their health check
+	FailureThreshold int  // failed new replicas tolerated before the update is paused
+}
+
+type UpdateStatus struct {
+	State      string
+	Message    string
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Revision is a snapshot of the task template, kept so updates can be rolled back
+type Revision struct {
+	Number    int
+	Template  task.Task
+	CreatedAt time.Time
+}
+
+var DefaultStrategy = UpdateStrategy{MaxSurge: 1, WaitForHealthy: true}
+
+func (s *Service) Validate() error {
+	if s.Name == "" {
+		return errors.New("service name is required")
+	}
+	if s.Replicas < 0 {
+		return fmt.Errorf("replicas can't be negative, got %d", s.Replicas)
+	}
+	if s.Template.Image == "" {
+		return errors.New("task template needs an image")
+	}
+	if err := s.Template.Validate(); err != nil {
+		return err
+	}
+	return s.Strategy.Validate()
+}
+
+func (us UpdateStrategy) Validate() error {
+	if us.MaxSurge < 0 || us.MaxUnavailable < 0 || us.FailureThreshold < 0 {
+		return errors.New("update strategy values can't be negative")
+	}
+	if us.MaxSurge == 0 && us.MaxUnavailable == 0 {
+		return errors.New("update strategy needs either max surge or max unavailable above zero")
+	}
+	return nil
+}
+
+func (s *Service) CurrentRevision() Revision {
+	return Revision{Number: s.Revision, Template: s.Template}
+}
+
+// NewTask creates a fresh replica out of the current template
+func (s *Service) NewTask() task.Task {
+	return s.NewTaskFromRevision(s.CurrentRevision())
+}
+
+func (s *Service) NewTaskFromRevision(r Revision) task.Task {
+	t := r.Template
+	t.ID = uuid.New()
+	// container names have to be unique on a worker
+	t.Name = fmt.Sprintf("%s-%s", s.Name, t.ID.String()[:8])
+	t.State = task.Pending
+	t.ServiceID = s.ID
+	t.ServiceRevision = r.Number
+	t.Labels = make(map[string]string)
+	for k, v := range r.Template.Labels {
+		t.Labels[k] = v
+	}
+	for k, v := range s.Labels {
+		t.Labels[k] = v
+	}
+	t.ContainerID = ""
+	t.HostPorts = nil
+	t.StartTime = time.Time{}
+	t.FinishTime = time.Time{}
+	t.RestartCount = 0
+	return t
+}
@@ -0,0 +1,43 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"path"
+)
+
+// ConfigRef mounts a config kept by the manager as a read-only file
+type ConfigRef struct {
+	Name    string
+	File    string // absolute path inside the container
+	Version int    // version the task was started with, set by the manager
+}
+
+func (r ConfigRef) Validate() error {
+	if r.Name == "" {
+		return errors.New("config reference needs a name")
+	}
+	if !path.IsAbs(r.File) {
+		return fmt.Errorf("config %s file %q has to be an absolute path", r.Name, r.File)
+	}
+	return nil
+}
+
+func (t *Task) validateConfigs() error {
+	for _, r := range t.Configs {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UsesConfig tells whether any of the task's files come from the config
+func (t *Task) UsesConfig(name string) bool {
+	for _, r := range t.Configs {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
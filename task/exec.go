@@ -0,0 +1,116 @@
+package task
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Upgrade token used by exec connections between the CLI, the manager and workers
+const ExecUpgrade = "cube-exec"
+
+// frame kinds on an exec connection
+const (
+	ExecStdin byte = iota
+	ExecStdout
+	ExecStderr
+	ExecStdinClose // client has no more input
+	ExecExit       // last frame sent by the worker, carries ExecResult
+)
+
+// frames mimic docker's multiplexed streams: [kind, 0, 0, 0, uint32 size] + payload
+const execFrameHeader = 8
+
+type ExecRequest struct {
+	Cmd []string
+	Env []string
+	Tty bool
+}
+
+type ExecResult struct {
+	ExitCode int
+	Error    string
+}
+
+func WriteExecFrame(w io.Writer, kind byte, payload []byte) error {
+	header := make([]byte, execFrameHeader)
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func ReadExecFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, execFrameHeader)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[4:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// ExecFrameWriter wraps every write into a frame of the given kind
+type ExecFrameWriter struct {
+	W    io.Writer
+	Kind byte
+}
+
+func (fw ExecFrameWriter) Write(p []byte) (int, error) {
+	if err := WriteExecFrame(fw.W, fw.Kind, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type ExecSession struct {
+	ID   string
+	Tty  bool
+	Conn types.HijackedResponse // raw stream when Tty is set, multiplexed otherwise
+}
+
+// essentially the same as docker exec -i from cli
+func (d *Docker) Exec(ctx context.Context, containerID string, req ExecRequest) (*ExecSession, error) {
+	if len(req.Cmd) == 0 {
+		return nil, fmt.Errorf("no command to execute")
+	}
+	created, err := d.Client.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd:          req.Cmd,
+		Env:          req.Env,
+		Tty:          req.Tty,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		log.Printf("Error creating exec in container %s: %v\n", containerID, err)
+		return nil, err
+	}
+	conn, err := d.Client.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{Tty: req.Tty})
+	if err != nil {
+		log.Printf("Error attaching to exec %s: %v\n", created.ID, err)
+		return nil, err
+	}
+	return &ExecSession{ID: created.ID, Tty: req.Tty, Conn: conn}, nil
+}
+
+func (d *Docker) ExecExitCode(ctx context.Context, execID string) (int, error) {
+	resp, err := d.Client.ContainerExecInspect(ctx, execID)
+	if err != nil {
+		log.Printf("Error inspecting exec %s: %v\n", execID, err)
+		return 0, err
+	}
+	if resp.Running {
+		return 0, fmt.Errorf("exec %s is still running", execID)
+	}
+	return resp.ExitCode, nil
+}
@@ -0,0 +1,78 @@
+package task
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+)
+
+// Image is an image cached on a worker
+type Image struct {
+	ID        string
+	Names     []string // tags and digests, normalized
+	Size      int64
+	CreatedAt time.Time
+	InUse     bool // some container, running or not, was created from it
+}
+
+// NormalizeImage spells out the registry and tag left implicit in an image name,
+// so that nginx and docker.io/library/nginx:latest compare equal
+func NormalizeImage(img string) string {
+	named, err := reference.ParseNormalizedNamed(img)
+	if err != nil {
+		return img
+	}
+	return reference.TagNameOnly(named).String()
+}
+
+// Pull fetches the image following the pull policy, without running anything
+func (d *Docker) Pull() error {
+	return d.pullImage(context.Background())
+}
+
+// Images lists the images docker has locally, oldest first
+func (d *Docker) Images() ([]Image, error) {
+	summaries, err := d.Client.ImageList(context.Background(), image.ListOptions{ContainerCount: true})
+	if err != nil {
+		return nil, err
+	}
+	images := make([]Image, 0, len(summaries))
+	for _, s := range summaries {
+		img := Image{
+			ID:        s.ID,
+			Size:      s.Size,
+			CreatedAt: time.Unix(s.Created, 0).UTC(),
+			InUse:     s.Containers > 0,
+		}
+		for _, name := range append(s.RepoTags, s.RepoDigests...) {
+			// untagged images are listed as <none>:<none>
+			if _, err := reference.ParseNormalizedNamed(name); err == nil {
+				img.Names = append(img.Names, NormalizeImage(name))
+			}
+		}
+		images = append(images, img)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].CreatedAt.Before(images[j].CreatedAt)
+	})
+	return images, nil
+}
+
+// DataRoot is where docker keeps images and containers on the host
+func (d *Docker) DataRoot() (string, error) {
+	info, err := d.Client.Info(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return info.DockerRootDir, nil
+}
+
+func (d *Docker) RemoveImage(id string) error {
+	// an image with several tags can only be removed by force, which is
+	// fine for images no container was created from
+	_, err := d.Client.ImageRemove(context.Background(), id, image.RemoveOptions{Force: true, PruneChildren: true})
+	return err
+}
@@ -0,0 +1,353 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// InitContainer is a setup step, like a migration, run before the task's own container.
+// It sees the same mounts, secrets and configs as the task.
+type InitContainer struct {
+	Name           string
+	Image          string // the task's image when not set
+	Cmd            []string
+	Env            []string
+	TimeoutSeconds int // how long it may run, DefaultInitTimeout when not set
+}
+
+// time an init container gets unless it says otherwise. A worker starts its
+// tasks one at a time, and nothing else starts there while an init container
+// runs, so the default is kept short: init containers expected to take longer,
+// like data migrations, should set TimeoutSeconds.
+const DefaultInitTimeout = 60
+
+func (c InitContainer) Timeout() time.Duration {
+	if c.TimeoutSeconds == 0 {
+		return DefaultInitTimeout * time.Second
+	}
+	return time.Duration(c.TimeoutSeconds) * time.Second
+}
+
+// docker's own grace period between the stop signal and killing the container
+const DefaultStopGracePeriod = 10
+
+// time a hook gets unless it says otherwise, so that a stuck one doesn't hold up the worker
+const DefaultHookTimeout = 30
+
+// Hook is run against the container of a task at a point of its life.
+// It either executes a command inside the container or calls it over HTTP.
+type Hook struct {
+	Exec           []string  `json:",omitempty"`
+	HTTP           *HTTPHook `json:",omitempty"`
+	TimeoutSeconds int       // how long the hook may take, 30 when not set and bounded by the grace period for pre-stop hooks
+}
+
+// HTTPHook is a GET request sent to the container, any status below 400 counts as success
+type HTTPHook struct {
+	Path string // "/" when not set
+	Port int
+}
+
+func (h *Hook) Validate() error {
+	if (len(h.Exec) == 0) == (h.HTTP == nil) {
+		return errors.New("hook needs either a command to exec or an HTTP request")
+	}
+	if h.HTTP != nil && (h.HTTP.Port < 1 || h.HTTP.Port > 65535) {
+		return fmt.Errorf("invalid HTTP hook port %d", h.HTTP.Port)
+	}
+	if h.TimeoutSeconds < 0 {
+		return errors.New("hook timeout can't be negative")
+	}
+	return nil
+}
+
+func (h *Hook) String() string {
+	if h.HTTP != nil {
+		return fmt.Sprintf("GET :%d%s", h.HTTP.Port, h.HTTP.path())
+	}
+	return strings.Join(h.Exec, " ")
+}
+
+func (h *HTTPHook) path() string {
+	if h.Path == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(h.Path, "/") {
+		return "/" + h.Path
+	}
+	return h.Path
+}
+
+// signal names like SIGTERM, TERM or SIGRTMIN+3, or plain numbers
+var stopSignalPattern = regexp.MustCompile(`^((SIG)?[A-Z][A-Z0-9]*([+-][0-9]+)?|[0-9]+)$`)
+
+func (t *Task) validateLifecycle() error {
+	names := make(map[string]bool)
+	for _, c := range t.InitContainers {
+		if c.Name == "" {
+			return errors.New("every init container needs a name")
+		}
+		if names[c.Name] {
+			return fmt.Errorf("init container %s is declared twice", c.Name)
+		}
+		if c.TimeoutSeconds < 0 {
+			return fmt.Errorf("timeout of init container %s can't be negative", c.Name)
+		}
+		names[c.Name] = true
+	}
+	if t.PostStart != nil {
+		if err := t.PostStart.Validate(); err != nil {
+			return fmt.Errorf("post-start %w", err)
+		}
+	}
+	return nil
+}
+
+func (t *Task) validateStop() error {
+	if t.StopSignal != "" && !stopSignalPattern.MatchString(t.StopSignal) {
+		return fmt.Errorf("invalid stop signal %q", t.StopSignal)
+	}
+	if t.StopGracePeriod < 0 {
+		return errors.New("stop grace period can't be negative")
+	}
+	if t.PreStop != nil {
+		if err := t.PreStop.Validate(); err != nil {
+			return fmt.Errorf("pre-stop %w", err)
+		}
+	}
+	return nil
+}
+
+// stopGracePeriod is how long stopping the container may take, the pre-stop hook included
+func (c *Config) stopGracePeriod() time.Duration {
+	if c.StopGracePeriod > 0 {
+		return time.Duration(c.StopGracePeriod) * time.Second
+	}
+	return DefaultStopGracePeriod * time.Second
+}
+
+// runHook runs a hook against a running container, failing when the hook does
+func (d *Docker) runHook(ctx context.Context, containerID string, h Hook) error {
+	timeout := h.TimeoutSeconds
+	if timeout == 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	if h.HTTP != nil {
+		return d.runHTTPHook(ctx, containerID, *h.HTTP)
+	}
+	return d.runExecHook(ctx, containerID, h.Exec)
+}
+
+func (d *Docker) runExecHook(ctx context.Context, containerID string, cmd []string) error {
+	created, err := d.Client.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return err
+	}
+	conn, err := d.Client.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the connection doesn't follow the context, so it gets closed when the hook times out
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	var output bytes.Buffer
+	_, err = stdcopy.StdCopy(&output, &output, conn.Reader)
+	if ctx.Err() != nil {
+		return fmt.Errorf("timed out: %w", ctx.Err())
+	}
+	if err != nil {
+		return err
+	}
+	exitCode, err := d.ExecExitCode(ctx, created.ID)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("exited with code %d: %s", exitCode, lastLine(output.String()))
+	}
+	return nil
+}
+
+func (d *Docker) runHTTPHook(ctx context.Context, containerID string, h HTTPHook) error {
+	ip, err := d.containerIP(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(ip, strconv.Itoa(h.Port)), h.path())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	hookResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer hookResp.Body.Close()
+	_, _ = io.Copy(io.Discard, hookResp.Body)
+	if hookResp.StatusCode >= 400 {
+		return fmt.Errorf("%s responded with %d", url, hookResp.StatusCode)
+	}
+	return nil
+}
+
+// containerIP finds where a container can be reached, which for containers
+// sharing the network of another one, like those of a group, is that one's address
+func (d *Docker) containerIP(ctx context.Context, containerID string) (string, error) {
+	resp, err := d.Client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+	if resp.HostConfig != nil && resp.HostConfig.NetworkMode.IsContainer() {
+		return d.containerIP(ctx, resp.HostConfig.NetworkMode.ConnectedContainer())
+	}
+	ip := ""
+	if resp.NetworkSettings != nil {
+		ip = resp.NetworkSettings.IPAddress
+		for _, n := range resp.NetworkSettings.Networks {
+			if ip == "" && n != nil {
+				ip = n.IPAddress
+			}
+		}
+	}
+	if ip == "" {
+		return "", fmt.Errorf("container %s has no IP address", containerID)
+	}
+	return ip, nil
+}
+
+// the end of the output is usually what tells why a command failed
+func lastLine(output string) string {
+	output = strings.TrimSpace(output)
+	if i := strings.LastIndex(output, "\n"); i >= 0 {
+		return output[i+1:]
+	}
+	return output
+}
+
+// preStop runs the pre-stop hook and returns how much of the grace period is left for the signal
+func (d *Docker) preStop(ctx context.Context, id string) time.Duration {
+	grace := d.Config.stopGracePeriod()
+	if d.Config.PreStop == nil {
+		return grace
+	}
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, grace)
+	defer cancel()
+	if err := d.runHook(ctx, id, *d.Config.PreStop); err != nil {
+		// the container gets stopped regardless
+		log.Printf("Pre-stop hook %s of container %s failed: %v\n", d.Config.PreStop, id, err)
+	}
+	return max(grace-time.Since(started), 0)
+}
+
+// InitConfig is the config of the task's container turned into one of its init containers
+func (c Config) InitConfig(init InitContainer) Config {
+	c.Name = fmt.Sprintf("%s-init-%s", c.Name, init.Name)
+	if init.Image != "" {
+		// the image may come from another registry than the task's own
+		c.Image = init.Image
+		c.PullPolicy = ""
+		c.RegistryAuth = c.InitRegistryAuth[init.Name]
+	}
+	c.InitRegistryAuth = nil
+	c.Cmd = init.Cmd
+	c.Env = append(append([]string{}, c.Env...), init.Env...)
+	c.ExposedPorts = nil
+	c.RestartPolicy = ""
+	c.PostStart = nil
+	c.PreStop = nil
+	c.KeepContainer = false
+	return c
+}
+
+// RunToCompletion runs the container, waits for it to exit and removes it.
+// A non-zero exit code is an error carrying the last line of the output.
+func (d *Docker) RunToCompletion(ctx context.Context) error {
+	if err := d.pullImage(ctx); err != nil {
+		return err
+	}
+	cc := container.Config{
+		Image: d.Config.Image,
+		Cmd:   d.Config.Cmd,
+		Env:   d.Config.Env,
+	}
+	hc := container.HostConfig{
+		Resources: container.Resources{
+			Memory:    d.Config.Memory,
+			NanoCPUs:  int64(d.Config.Cpu * math.Pow(10, 9)),
+			CPUShares: int64(d.Config.CpuRequest * 1024),
+		},
+		Mounts:      dockerMounts(d.Config.Mounts),
+		NetworkMode: container.NetworkMode(d.Config.NetworkMode),
+	}
+	resp, err := d.Client.ContainerCreate(ctx, &cc, &hc, nil, nil, d.Config.Name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := d.Client.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true}); err != nil {
+			log.Printf("Error removing container %s: %v\n", resp.ID, err)
+		}
+	}()
+	statusCh, errCh := d.Client.ContainerWait(ctx, resp.ID, container.WaitConditionNextExit)
+	if err := d.Client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return err
+	}
+	var exitCode int64
+	select {
+	case err := <-errCh:
+		return err
+	case status := <-statusCh:
+		if status.Error != nil {
+			return errors.New(status.Error.Message)
+		}
+		exitCode = status.StatusCode
+	}
+	if exitCode == 0 {
+		return nil
+	}
+	var output bytes.Buffer
+	if out, err := d.Client.ContainerLogs(ctx, resp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true, Tail: "20"}); err == nil {
+		_, _ = stdcopy.StdCopy(&output, &output, out)
+		out.Close()
+	}
+	return fmt.Errorf("exited with code %d: %s", exitCode, lastLine(output.String()))
+}
+
+// PostStart runs the post-start hook against the started container, if there is one
+func (d *Docker) PostStart(containerID string) error {
+	if d.Config.PostStart == nil {
+		return nil
+	}
+	return d.runHook(context.Background(), containerID, *d.Config.PostStart)
+}
@@ -0,0 +1,85 @@
+package task
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/docker/docker/api/types/mount"
+	"kjarmicki.github.com/cube/volume"
+)
+
+// kinds of storage a task can mount
+const (
+	MountVolume = "volume" // named volume, created on first use
+	MountBind   = "bind"   // path on the worker host, subject to the worker's allowlist
+	MountLocal  = "local"  // persistent volume of a worker, which the task gets pinned to
+)
+
+type Mount struct {
+	Type     string
+	Source   string // volume name or host path
+	Target   string // path inside the container
+	ReadOnly bool
+}
+
+func (m Mount) Validate() error {
+	switch m.Type {
+	case MountVolume, MountBind, MountLocal:
+	default:
+		return fmt.Errorf("unknown mount type %q, expected %s, %s or %s", m.Type, MountVolume, MountBind, MountLocal)
+	}
+	if m.Source == "" {
+		return fmt.Errorf("%s mount at %s needs a source", m.Type, m.Target)
+	}
+	if m.Type == MountBind && !path.IsAbs(m.Source) {
+		return fmt.Errorf("bind mount source %s has to be an absolute path", m.Source)
+	}
+	if m.Type == MountLocal {
+		if err := volume.ValidateName(m.Source); err != nil {
+			return err
+		}
+	}
+	if !path.IsAbs(m.Target) {
+		return fmt.Errorf("mount target %q has to be an absolute path", m.Target)
+	}
+	return nil
+}
+
+func (t *Task) validateMounts() error {
+	targets := make(map[string]bool)
+	for _, m := range t.Mounts {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+		target := path.Clean(m.Target)
+		if targets[target] {
+			return fmt.Errorf("more than one mount at %s", target)
+		}
+		targets[target] = true
+	}
+	return nil
+}
+
+// LocalVolumes lists the persistent volumes claimed by the task
+func (t *Task) LocalVolumes() []string {
+	var names []string
+	for _, m := range t.Mounts {
+		if m.Type == MountLocal {
+			names = append(names, m.Source)
+		}
+	}
+	return names
+}
+
+func dockerMounts(mounts []Mount) []mount.Mount {
+	var dm []mount.Mount
+	for _, m := range mounts {
+		dm = append(dm, mount.Mount{
+			Type:     mount.Type(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+	return dm
+}
@@ -0,0 +1,193 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+)
+
+// image pull policies
+const (
+	PullAlways       = "always"
+	PullIfNotPresent = "if-not-present"
+	PullNever        = "never"
+)
+
+// PullError is returned when the image of a task can't be had
+type PullError struct {
+	Image  string
+	Reason string
+	Err    error
+}
+
+func (e *PullError) Error() string {
+	return fmt.Sprintf("image %s: %v", e.Image, e.Err)
+}
+
+func (e *PullError) Unwrap() error {
+	return e.Err
+}
+
+func ValidatePullPolicy(policy string) error {
+	switch policy {
+	case "", PullAlways, PullIfNotPresent, PullNever:
+		return nil
+	}
+	return fmt.Errorf("unknown pull policy %q, expected %s, %s or %s", policy, PullAlways, PullIfNotPresent, PullNever)
+}
+
+// DefaultPullPolicy pulls images with moving tags every time, and the rest only once
+func DefaultPullPolicy(img string) string {
+	if strings.Contains(img, "@") {
+		return PullIfNotPresent
+	}
+	name := img[strings.LastIndex(img, "/")+1:]
+	if !strings.Contains(name, ":") || strings.HasSuffix(name, ":latest") {
+		return PullAlways
+	}
+	return PullIfNotPresent
+}
+
+func (d *Docker) pullImage(ctx context.Context) error {
+	policy := d.Config.PullPolicy
+	if policy == "" {
+		policy = DefaultPullPolicy(d.Config.Image)
+	}
+	if policy != PullAlways {
+		_, _, err := d.Client.ImageInspectWithRaw(ctx, d.Config.Image)
+		if err == nil {
+			return nil
+		}
+		if !client.IsErrNotFound(err) {
+			return &PullError{Image: d.Config.Image, Reason: ReasonImagePullFailed, Err: err}
+		}
+		if policy == PullNever {
+			return &PullError{Image: d.Config.Image, Reason: ReasonImageNotPresent, Err: fmt.Errorf("not present and the pull policy is %s", PullNever)}
+		}
+	}
+
+	reader, err := d.Client.ImagePull(ctx, d.Config.Image, image.PullOptions{RegistryAuth: d.Config.RegistryAuth})
+	if err != nil {
+		return &PullError{Image: d.Config.Image, Reason: ReasonImagePullFailed, Err: err}
+	}
+	defer reader.Close()
+	// failures half way through, like a missing tag, only show up in the stream
+	if err := readPullStream(reader, d.Config.Image, d.Config.PullProgress); err != nil {
+		return &PullError{Image: d.Config.Image, Reason: ReasonImagePullFailed, Err: err}
+	}
+	return nil
+}
+
+// single line of the JSON stream docker sends while pulling
+type pullMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Progress       string `json:"progress"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	ErrorDetail *struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// PullProgress sums up where pulling the image of a task got to
+type PullProgress struct {
+	Image      string
+	Layers     int   // layers of the image known so far
+	LayersDone int   // layers downloaded and extracted, or already present
+	Downloaded int64 // bytes
+	Total      int64 // bytes, only counting layers whose size is known so far
+	StartedAt  time.Time
+	UpdatedAt  time.Time // stops moving when the pull hangs
+}
+
+type layerProgress struct {
+	done       bool
+	downloaded int64
+	total      int64
+}
+
+// pullTracker follows the layers mentioned in the pull stream
+type pullTracker struct {
+	progress PullProgress
+	layers   map[string]*layerProgress
+	order    []string
+}
+
+func newPullTracker(img string) *pullTracker {
+	now := time.Now().UTC()
+	return &pullTracker{
+		progress: PullProgress{Image: img, StartedAt: now, UpdatedAt: now},
+		layers:   make(map[string]*layerProgress),
+	}
+}
+
+// update applies a message and tells whether it was about a layer
+func (p *pullTracker) update(msg pullMessage) bool {
+	// messages about the image as a whole either carry no id, or the tag as one
+	if msg.ID == "" || strings.HasPrefix(msg.Status, "Pulling from") {
+		return false
+	}
+	l, ok := p.layers[msg.ID]
+	if !ok {
+		l = &layerProgress{}
+		p.layers[msg.ID] = l
+		p.order = append(p.order, msg.ID)
+	}
+	switch msg.Status {
+	case "Downloading":
+		l.downloaded = msg.ProgressDetail.Current
+		l.total = msg.ProgressDetail.Total
+	case "Download complete":
+		l.downloaded = l.total
+	case "Pull complete", "Already exists":
+		l.downloaded = l.total
+		l.done = true
+	}
+
+	p.progress.Layers = len(p.order)
+	p.progress.LayersDone, p.progress.Downloaded, p.progress.Total = 0, 0, 0
+	for _, id := range p.order {
+		layer := p.layers[id]
+		if layer.done {
+			p.progress.LayersDone++
+		}
+		p.progress.Downloaded += layer.downloaded
+		p.progress.Total += layer.total
+	}
+	p.progress.UpdatedAt = time.Now().UTC()
+	return true
+}
+
+// readPullStream waits for the pull to finish, handing the progress to report along the way
+func readPullStream(r io.Reader, img string, report func(PullProgress)) error {
+	d := json.NewDecoder(r)
+	tracker := newPullTracker(img)
+	for {
+		var msg pullMessage
+		if err := d.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.ErrorDetail != nil {
+			return errors.New(msg.ErrorDetail.Message)
+		}
+		if msg.Status != "Downloading" && msg.Status != "Extracting" {
+			log.Printf("%s %s\n", msg.ID, msg.Status)
+		}
+		if tracker.update(msg) && report != nil {
+			report(tracker.progress)
+		}
+	}
+}
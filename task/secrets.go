@@ -0,0 +1,50 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"path"
+)
+
+// SecretRef points a task at a secret kept by the manager. The worker hands its
+// value to the container either as an env var or as a file on tmpfs.
+type SecretRef struct {
+	Name string
+	Env  string // name of the env var
+	File string // absolute path inside the container
+}
+
+func (r SecretRef) Validate() error {
+	if r.Name == "" {
+		return errors.New("secret reference needs a name")
+	}
+	if (r.Env == "") == (r.File == "") {
+		return fmt.Errorf("secret %s needs either an env var or a file, but not both", r.Name)
+	}
+	if r.File != "" && !path.IsAbs(r.File) {
+		return fmt.Errorf("secret %s file %q has to be an absolute path", r.Name, r.File)
+	}
+	return nil
+}
+
+func (t *Task) validateSecrets() error {
+	for _, r := range t.Secrets {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SecretNames lists the distinct secrets referenced by the task
+func (t *Task) SecretNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, r := range t.Secrets {
+		if !seen[r.Name] {
+			seen[r.Name] = true
+			names = append(names, r.Name)
+		}
+	}
+	return names
+}
@@ -1,11 +1,27 @@
 package task
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
 var stateTransitionsMap = map[State][]State{
-	Pending:   {Scheduled},
+	Pending:   {Scheduled, Skipped},
 	Scheduled: {Scheduled, Running, Failed},
 	Running:   {Running, Completed, Failed},
 	Completed: {},
 	Failed:    {},
+	Skipped:   {},
+}
+
+var stateNames = map[State]string{
+	Pending:   "pending",
+	Scheduled: "scheduled",
+	Running:   "running",
+	Completed: "completed",
+	Failed:    "failed",
+	Skipped:   "skipped",
 }
 
 func Contains(states []State, state State) bool {
@@ -20,3 +36,56 @@ func Contains(states []State, state State) bool {
 func ValidateTransition(src State, dst State) bool {
 	return Contains(stateTransitionsMap[src], dst)
 }
+
+func ParseState(s string) (State, error) {
+	for state, name := range stateNames {
+		if name == s {
+			return state, nil
+		}
+	}
+	// integers are still accepted for backward compatibility
+	if n, err := strconv.Atoi(s); err == nil {
+		if _, ok := stateNames[State(n)]; ok {
+			return State(n), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown task state %q", s)
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	name, ok := stateNames[s]
+	if !ok {
+		return nil, fmt.Errorf("unknown task state %d", int(s))
+	}
+	return []byte(name), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+	state, err := ParseState(string(text))
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
+}
+
+// encoding/json refuses to hand bare numbers to UnmarshalText,
+// so plain integers are handled here
+func (s *State) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		return s.UnmarshalText([]byte(name))
+	}
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("task state must be a string or an integer, got %s", data)
+	}
+	return s.UnmarshalText([]byte(strconv.Itoa(n)))
+}
@@ -2,15 +2,18 @@ package task
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"math"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
@@ -25,34 +28,125 @@ const (
 	Running                // worker has successfully started a task
 	Completed              // task didn't fail and finished
 	Failed
+	Skipped // task was never run, because a task it depended on didn't succeed
+)
+
+// reasons for a task failure
+const (
+	ReasonImagePullFailed     = "ImagePullFailed"
+	ReasonImageNotPresent     = "ImageNotPresent"
+	ReasonStartFailed         = "StartFailed"
+	ReasonInitContainerFailed = "InitContainerFailed"
+	ReasonPostStartHookFailed = "PostStartHookFailed"
+)
+
+// sub-statuses of a scheduled task
+const (
+	SubstatusPulling      = "Pulling"
+	SubstatusInitializing = "Initializing" // init containers are running
 )
 
 type Task struct {
-	ID          uuid.UUID
-	ContainerID string
-	Name        string
-	State       State
+	ID              uuid.UUID
+	ContainerID     string
+	Name            string
+	State           State
+	Labels          map[string]string
+	ServiceID       uuid.UUID // set when the task is a replica managed by a service
+	ServiceRevision int       // revision of the service template the task was created from
+	JobID           uuid.UUID // set when the task is a run of a batch job
+	WorkflowID      uuid.UUID // set when the task is a step of a workflow
+	GroupID         uuid.UUID // set when the task is a container of a task group
+	InfraTaskID     uuid.UUID // task holding the network and resources of the task's group
+	Init            bool      // init container of a group, runs to completion before the rest start
+	Priority        int       // higher priority tasks are scheduled first and may evict lower priority ones
+	PendingReason   string    // why the task hasn't been scheduled yet
+	Substatus       string    // what a scheduled task is busy with on its worker, like Pulling
+	// how far pulling the image got, while the task is Pulling
+	PullProgress *PullProgress `json:",omitempty"`
 	// container-specific properties
 	Image         string
+	Cpu           float64 // cores requested, counted when scheduling
+	CpuLimit      float64 // cores the container may use at most, unlimited when not set
 	Memory        int
-	Disk          int
+	Disk          int // in bytes, enforced where the storage driver supports quotas
+	Mounts        []Mount
+	Secrets       []SecretRef // only references, values never leave the manager other than to the worker
+	Configs       []ConfigRef
 	HostPorts     nat.PortMap
 	ExposedPorts  nat.PortSet
 	PortBindings  map[string]string
 	RestartPolicy string
+	PullPolicy    string // always, if-not-present or never, depending on the image tag when not set
 	// timings
 	StartTime  time.Time
 	FinishTime time.Time
+	ExitCode   int
+	// why the task failed, a short reason like ImagePullFailed along with the details
+	FailureReason  string
+	FailureMessage string
+	// lifecycle
+	InitContainers []InitContainer // run one after another to completion before the task's container starts
+	PostStart      *Hook           `json:",omitempty"` // runs once the container started, failing the task when it fails
+	// stopping
+	StopSignal      string // sent to the container first, SIGTERM when not set
+	StopGracePeriod int    // seconds between the stop signal and killing the container, 10 when not set
+	PreStop         *Hook  `json:",omitempty"` // runs before the stop signal, within the grace period
+	KeepContainer   bool   // leave the stopped container around for inspection instead of removing it
 	// health check
 	HealthCheck  string
 	RestartCount int
 }
 
+func (t *Task) Validate() error {
+	if err := t.ValidateResources(); err != nil {
+		return err
+	}
+	if err := t.validateMounts(); err != nil {
+		return err
+	}
+	if err := ValidatePullPolicy(t.PullPolicy); err != nil {
+		return err
+	}
+	if err := t.validateLifecycle(); err != nil {
+		return err
+	}
+	if err := t.validateStop(); err != nil {
+		return err
+	}
+	if err := t.validateSecrets(); err != nil {
+		return err
+	}
+	return t.validateConfigs()
+}
+
+func (t *Task) ValidateResources() error {
+	if t.Cpu < 0 || t.CpuLimit < 0 || t.Memory < 0 || t.Disk < 0 {
+		return errors.New("cpu, memory and disk can't be negative")
+	}
+	if t.CpuLimit > 0 && t.CpuLimit < t.Cpu {
+		return fmt.Errorf("cpu limit %g is below the requested %g cores", t.CpuLimit, t.Cpu)
+	}
+	return nil
+}
+
+// batch tasks are done once their container exits cleanly, instead of
+// being restarted like long running ones
+func (t *Task) RunsToCompletion() bool {
+	return t.JobID != uuid.Nil || t.WorkflowID != uuid.Nil || t.Init
+}
+
 type TaskEvent struct {
 	ID        uuid.UUID
 	State     State
 	Timestamp time.Time
 	Task      Task
+	Secrets   map[string][]byte `json:",omitempty"` // values of the secrets the task references, sent to the worker only
+	Configs   map[string][]byte `json:",omitempty"` // contents of the configs the task mounts
+	// credentials of the registry the image comes from, sent to the worker only
+	RegistryAuth string `json:",omitempty"`
+	// the same for the images of init containers, by init container name
+	InitRegistryAuth map[string]string `json:",omitempty"`
 }
 
 type Config struct {
@@ -63,21 +157,44 @@ type Config struct {
 	ExposedPorts  nat.PortSet
 	Cmd           []string
 	Image         string
-	Cpu           float64
+	Cpu           float64 // limit, in cores
+	CpuRequest    float64 // relative weight when cores are contended
 	Memory        int64
 	Disk          int64
+	Mounts        []Mount
 	Env           []string
 	RestartPolicy string
+	PullPolicy    string
+	RegistryAuth  string // encoded credentials of the registry the image comes from
+	// credentials for the images of init containers, by init container name
+	InitRegistryAuth map[string]string
+	PullProgress     func(PullProgress) `json:"-"` // called as the image is being pulled
+	NetworkMode      string             // container:<id> joins the network namespace of another container
+	PostStart        *Hook
+	// stopping
+	StopSignal      string
+	StopGracePeriod int
+	PreStop         *Hook
+	KeepContainer   bool
 }
 
 func NewConfig(t *Task) Config {
 	return Config{
-		Name:          t.Name,
-		ExposedPorts:  t.ExposedPorts,
-		Image:         t.Image,
-		Memory:        int64(t.Memory),
-		Disk:          int64(t.Disk),
-		RestartPolicy: t.RestartPolicy,
+		Name:            t.Name,
+		ExposedPorts:    t.ExposedPorts,
+		Image:           t.Image,
+		Cpu:             t.CpuLimit,
+		CpuRequest:      t.Cpu,
+		Memory:          int64(t.Memory),
+		Disk:            int64(t.Disk),
+		Mounts:          t.Mounts,
+		RestartPolicy:   t.RestartPolicy,
+		PullPolicy:      t.PullPolicy,
+		PostStart:       t.PostStart,
+		StopSignal:      t.StopSignal,
+		StopGracePeriod: t.StopGracePeriod,
+		PreStop:         t.PreStop,
+		KeepContainer:   t.KeepContainer,
 	}
 }
 
@@ -91,6 +208,7 @@ type DockerResult struct {
 	Action      string
 	ContainerId string
 	Result      string
+	ExitCode    int
 }
 
 type Docker struct {
@@ -109,12 +227,10 @@ func NewDocker(c Config) *Docker {
 // essentially the same as docker run from cli
 func (d *Docker) Run() DockerResult {
 	ctx := context.Background()
-	reader, err := d.Client.ImagePull(ctx, d.Config.Image, image.PullOptions{})
-	if err != nil {
+	if err := d.pullImage(ctx); err != nil {
 		log.Printf("Error pulling image %s: %v\n", d.Config.Image, err)
 		return DockerResult{Error: err}
 	}
-	_, _ = io.Copy(os.Stdout, reader)
 
 	rp := container.RestartPolicy{
 		Name: container.RestartPolicyMode(d.Config.RestartPolicy),
@@ -122,20 +238,43 @@ func (d *Docker) Run() DockerResult {
 	r := container.Resources{ // resources required by the container
 		Memory:   d.Config.Memory,
 		NanoCPUs: int64(d.Config.Cpu * math.Pow(10, 9)),
+		// docker's default weight of 1024 stands for a single core
+		CPUShares: int64(d.Config.CpuRequest * 1024),
+	}
+	exposedPorts := d.Config.ExposedPorts
+	if d.Config.NetworkMode != "" {
+		// ports are published by the container owning the network
+		exposedPorts = nil
 	}
 	cc := container.Config{
 		Image:        d.Config.Image,
 		Tty:          false,
 		Env:          d.Config.Env,
-		ExposedPorts: d.Config.ExposedPorts,
+		ExposedPorts: exposedPorts,
+		StopSignal:   d.Config.StopSignal,
+	}
+	if d.Config.StopGracePeriod > 0 {
+		// also applies when docker itself stops the container
+		cc.StopTimeout = &d.Config.StopGracePeriod
 	}
 	hc := container.HostConfig{
 		RestartPolicy:   rp,
 		Resources:       r,
-		PublishAllPorts: true, // docker will expose all ports automatically, randomly choosing available ports on host
+		PublishAllPorts: d.Config.NetworkMode == "", // docker will expose all ports automatically, randomly choosing available ports on host
+		Mounts:          dockerMounts(d.Config.Mounts),
+		NetworkMode:     container.NetworkMode(d.Config.NetworkMode),
+	}
+	if d.Config.Disk > 0 {
+		hc.StorageOpt = map[string]string{"size": strconv.FormatInt(d.Config.Disk, 10)}
 	}
 
 	resp, err := d.Client.ContainerCreate(ctx, &cc, &hc, nil, nil, d.Config.Name)
+	if err != nil && hc.StorageOpt != nil && strings.Contains(err.Error(), "storage-opt") {
+		// quotas depend on the storage driver and its backing filesystem
+		log.Printf("Disk quota not supported, creating container %s without it: %v\n", d.Config.Name, err)
+		hc.StorageOpt = nil
+		resp, err = d.Client.ContainerCreate(ctx, &cc, &hc, nil, nil, d.Config.Name)
+	}
 	if err != nil {
 		log.Printf("Error creating container using image %s: %v\n", d.Config.Image, err)
 		return DockerResult{Error: err}
@@ -182,22 +321,79 @@ func (d *Docker) Inspect(containerID string) DockerInspectResponse {
 	}
 }
 
+type LogsOptions struct {
+	Stdout     bool
+	Stderr     bool
+	Tail       string // number of lines from the end, or "all"
+	Since      string // timestamp or relative duration, e.g. 2024-05-01T10:00:00Z or 10m
+	Follow     bool
+	Timestamps bool
+}
+
+// OpenLogs starts reading container output, failing right away when there's
+// no such container, so that callers can tell that apart from empty output
+func (d *Docker) OpenLogs(ctx context.Context, containerID string, opts LogsOptions) (io.ReadCloser, error) {
+	out, err := d.Client.ContainerLogs(ctx, containerID, container.LogsOptions{
+		ShowStdout: opts.Stdout,
+		ShowStderr: opts.Stderr,
+		Tail:       opts.Tail,
+		Since:      opts.Since,
+		Follow:     opts.Follow,
+		Timestamps: opts.Timestamps,
+	})
+	if err != nil {
+		log.Printf("Error getting logs for container %s: %v\n", containerID, err)
+		return nil, err
+	}
+	return out, nil
+}
+
+// CopyLogs demultiplexes output opened with OpenLogs into the given writers and closes it
+func CopyLogs(ctx context.Context, out io.ReadCloser, stdout io.Writer, stderr io.Writer) error {
+	defer out.Close()
+	_, err := stdcopy.StdCopy(stdout, stderr, out)
+	if err != nil && ctx.Err() != nil {
+		// follow was interrupted by the caller going away
+		return nil
+	}
+	return err
+}
+
+// Stop runs the pre-stop hook, signals the container and kills it once the grace
+// period is over. The container is removed afterwards, unless it's meant to be kept.
 func (d *Docker) Stop(id string) DockerResult {
 	ctx := context.Background()
-	err := d.Client.ContainerStop(ctx, id, container.StopOptions{})
+	// a partial second left of the grace period still counts
+	timeout := int(math.Ceil(d.preStop(ctx, id).Seconds()))
+	err := d.Client.ContainerStop(ctx, id, container.StopOptions{Signal: d.Config.StopSignal, Timeout: &timeout})
 	if err != nil {
 		log.Printf("Error stopping container %s: %v\n", id, err)
 		return DockerResult{Error: err}
 	}
 
-	err = d.Client.ContainerRemove(ctx, id, container.RemoveOptions{})
-	if err != nil {
+	// exit code is only known until the container is removed
+	exitCode := 0
+	if resp, err := d.Client.ContainerInspect(ctx, id); err == nil {
+		exitCode = resp.State.ExitCode
+	}
+
+	if d.Config.KeepContainer {
+		// the task's name goes free for whatever replaces it
+		if d.Config.Name != "" {
+			kept := fmt.Sprintf("%s-%s", d.Config.Name, id[:min(12, len(id))])
+			if err := d.Client.ContainerRename(ctx, id, kept); err != nil {
+				log.Printf("Error renaming kept container %s: %v\n", id, err)
+			}
+		}
+		log.Printf("Keeping stopped container %s\n", id)
+	} else if err = d.Client.ContainerRemove(ctx, id, container.RemoveOptions{}); err != nil {
 		log.Printf("Error removing container %s: %v\n", id, err)
 		return DockerResult{Error: err}
 	}
 
 	return DockerResult{
-		Action: "stop",
-		Result: "success",
+		Action:   "stop",
+		Result:   "success",
+		ExitCode: exitCode,
 	}
 }
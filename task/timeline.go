@@ -0,0 +1,50 @@
+package task
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// kinds of entries recorded in a task timeline
+const (
+	TimelineSubmitted         = "submitted"
+	TimelineScheduled         = "scheduled"
+	TimelineRequeued          = "requeued"
+	TimelineRejected          = "rejected"
+	TimelineStarted           = "started"
+	TimelineHealthCheckFailed = "health check failed"
+	TimelineRestarted         = "restarted"
+	TimelineStopRequested     = "stop requested"
+	TimelineStopped           = "stopped"
+	TimelineFailed            = "failed"
+	TimelineSkipped           = "skipped"
+	TimelineEvicted           = "evicted"
+)
+
+// single, immutable entry in the history of a task
+type TimelineEvent struct {
+	TaskID    uuid.UUID
+	Timestamp time.Time
+	Kind      string
+	State     State
+	Node      string
+	Reason    string
+}
+
+func TimelineKindForState(s State) string {
+	switch s {
+	case Scheduled:
+		return TimelineScheduled
+	case Running:
+		return TimelineStarted
+	case Completed:
+		return TimelineStopped
+	case Failed:
+		return TimelineFailed
+	case Skipped:
+		return TimelineSkipped
+	default:
+		return s.String()
+	}
+}
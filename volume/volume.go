@@ -0,0 +1,26 @@
+package volume
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Volume is a directory kept by a worker under its data dir. It outlives the
+// tasks using it, which is why tasks claiming it only ever run on that worker.
+type Volume struct {
+	Name      string
+	Node      string // worker holding the volume
+	Path      string // location on the worker host
+	CreatedAt time.Time
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// names end up as directory names, so they can't point anywhere else
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid volume name %q, use letters, digits, '_', '.' and '-'", name)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/task"
+)
+
+// SetConfigs holds on to the contents of the configs sent along with a task until it's started
+func (w *Worker) SetConfigs(taskID uuid.UUID, contents map[string][]byte) {
+	w.configsMu.Lock()
+	defer w.configsMu.Unlock()
+	if w.configs == nil {
+		w.configs = make(map[uuid.UUID]map[string][]byte)
+	}
+	w.configs[taskID] = contents
+}
+
+func (w *Worker) takeConfigs(taskID uuid.UUID) map[string][]byte {
+	w.configsMu.Lock()
+	defer w.configsMu.Unlock()
+	contents := w.configs[taskID]
+	delete(w.configs, taskID)
+	return contents
+}
+
+func (w *Worker) configsDir(taskID uuid.UUID) string {
+	return filepath.Join(w.DataDir, "configs", taskID.String())
+}
+
+// materializeConfigs writes the configs of a task to files mounted read-only into its container
+func (w *Worker) materializeConfigs(t task.Task) ([]task.Mount, error) {
+	if len(t.Configs) == 0 {
+		return nil, nil
+	}
+	contents := w.takeConfigs(t.ID)
+	dir := w.configsDir(t.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var mounts []task.Mount
+	for i, r := range t.Configs {
+		data, ok := contents[r.Name]
+		if !ok {
+			return nil, fmt.Errorf("config %s wasn't sent along with the task", r.Name)
+		}
+		p := filepath.Join(dir, fmt.Sprintf("%d-%s", i, r.Name))
+		if err := os.WriteFile(p, data, 0o444); err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, task.Mount{Type: task.MountBind, Source: p, Target: r.File, ReadOnly: true})
+	}
+	return mounts, nil
+}
+
+func (w *Worker) removeConfigs(taskID uuid.UUID) {
+	w.takeConfigs(taskID)
+	_ = os.RemoveAll(w.configsDir(taskID))
+}
@@ -1,11 +1,15 @@
 package worker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 
+	"github.com/docker/docker/client"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"kjarmicki.github.com/cube/task"
@@ -18,50 +22,6 @@ type Api struct {
 	Router  *chi.Mux
 }
 
-type MemStats struct {
-	MemTotal     int `json:"mem_total"`
-	MemFree      int `json:"mem_free"`
-	MemAvailable int `json:"mem_available"`
-}
-
-type DiskStats struct {
-	All        int64 `json:"all"`
-	Used       int64 `json:"used"`
-	Free       int64 `json:"free"`
-	FreeInodes int   `json:"freeInodes"`
-}
-
-type CpuStats struct {
-	ID        string `json:"id"`
-	User      int    `json:"user"`
-	Nice      int    `json:"nice"`
-	System    int    `json:"system"`
-	Idle      int    `json:"idle"`
-	Iowait    int    `json:"iowait"`
-	Irq       int    `json:"irq"`
-	Softirq   int    `json:"softirq"`
-	Steal     int    `json:"steal"`
-	Guest     int    `json:"guest"`
-	GuestNice int    `json:"guest_nice"`
-}
-
-type LoadStats struct {
-	Last1Min       float64 `json:"last1min"`
-	Last5Min       float64 `json:"last5min"`
-	Last15Min      float64 `json:"last15min"`
-	ProcessRunning int     `json:"process_running"`
-	ProcessTotal   int     `json:"process_total"`
-	LastPID        int     `json:"last_pid"`
-}
-
-type Stats struct {
-	MemStats  MemStats  `json:"MemStats"`
-	DiskStats DiskStats `json:"DiskStats"`
-	CpuStats  CpuStats  `json:"CpuStats"`
-	LoadStats LoadStats `json:"LoadStats"`
-	TaskCount int       `json:"TaskCount"`
-}
-
 type ErrResponse struct {
 	Message string
 }
@@ -73,10 +33,21 @@ func (a *Api) initRouter() {
 		r.Get("/", a.GetTasksHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
 			r.Delete("/", a.StopTaskHandler)
+			r.Get("/logs", a.GetTaskLogsHandler)
+			r.Post("/exec", a.ExecTaskHandler)
 		})
 	})
 	a.Router.Route("/stats", func(r chi.Router) {
-		r.Get("/", a.FakeStats)
+		r.Get("/", a.GetStatsHandler)
+	})
+	a.Router.Route("/images", func(r chi.Router) {
+		r.Get("/", a.GetImagesHandler)
+		r.Post("/", a.PullImageHandler)
+	})
+	a.Router.Route("/volumes", func(r chi.Router) {
+		r.Get("/", a.GetVolumesHandler)
+		r.Post("/", a.CreateVolumeHandler)
+		r.Delete("/{name}", a.DeleteVolumeHandler)
 	})
 }
 
@@ -101,6 +72,25 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if te.Task.State != task.Completed {
+		if err := a.Worker.CheckMounts(te.Task); err != nil {
+			writeError(w, 400, err.Error())
+			return
+		}
+	}
+
+	if te.Secrets != nil {
+		a.Worker.SetSecrets(te.Task.ID, te.Secrets)
+	}
+	if te.Configs != nil {
+		a.Worker.SetConfigs(te.Task.ID, te.Configs)
+	}
+	if te.RegistryAuth != "" || te.InitRegistryAuth != nil {
+		a.Worker.SetRegistryAuth(te.Task.ID, te.RegistryAuth, te.InitRegistryAuth)
+	}
+	if te.Task.State == task.Completed {
+		a.Worker.CancelInit(te.Task.ID)
+	}
 	a.Worker.AddTask(te.Task)
 	log.Printf("Added task %s\n", te.Task.ID)
 	w.WriteHeader(201)
@@ -134,6 +124,7 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.Worker.CancelInit(tID)
 	taskCopy := *taskToStop
 	taskCopy.State = task.Completed
 	a.Worker.AddTask(taskCopy)
@@ -142,43 +133,152 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(204)
 }
 
-func (a *Api) FakeStats(w http.ResponseWriter, r *http.Request) {
-	stats := Stats{
-		MemStats: MemStats{
-			MemTotal:     32488372,
-			MemFree:      14399056,
-			MemAvailable: 23306576,
-		},
-		DiskStats: DiskStats{
-			All:        1006660349952,
-			Used:       39346565120,
-			Free:       967313784832,
-			FreeInodes: 61645909,
-		},
-		CpuStats: CpuStats{
-			ID:        "cpu",
-			User:      4819423,
-			Nice:      701,
-			System:    2140212,
-			Idle:      502094668,
-			Iowait:    14448,
-			Irq:       561115,
-			Softirq:   178454,
-			Steal:     0,
-			Guest:     0,
-			GuestNice: 0,
-		},
-		LoadStats: LoadStats{
-			Last1Min:       0.78,
-			Last5Min:       0.55,
-			Last15Min:      0.43,
-			ProcessRunning: 2,
-			ProcessTotal:   2336,
-			LastPID:        581117,
-		},
-		TaskCount: 0,
+// GetTaskLogsHandler streams container output of a task. Supported parameters:
+// stdout, stderr (both true by default), tail, since, follow and timestamps.
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, 400, "TaskID passed in the request looks invalid")
+		return
+	}
+	t, ok := a.Worker.Db[tID]
+	if !ok {
+		writeError(w, 404, fmt.Sprintf("Task %s not found", tID))
+		return
+	}
+	if t.ContainerID == "" {
+		writeError(w, 409, fmt.Sprintf("Task %s has no container yet", tID))
+		return
 	}
 
+	q := r.URL.Query()
+	opts := task.LogsOptions{
+		Tail:  q.Get("tail"),
+		Since: q.Get("since"),
+	}
+	flags := []struct {
+		name  string
+		value *bool
+		def   bool
+	}{
+		{"stdout", &opts.Stdout, true},
+		{"stderr", &opts.Stderr, true},
+		{"follow", &opts.Follow, false},
+		{"timestamps", &opts.Timestamps, false},
+	}
+	for _, f := range flags {
+		*f.value = f.def
+		if v := q.Get(f.name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				writeError(w, 400, fmt.Sprintf("Invalid value %q for %s", v, f.name))
+				return
+			}
+			*f.value = b
+		}
+	}
+
+	logs, err := a.Worker.TaskLogs(r.Context(), *t, opts)
+	if client.IsErrNotFound(err) {
+		writeError(w, 404, fmt.Sprintf("Container of task %s not found", tID))
+		return
+	}
+	if err != nil {
+		writeError(w, 500, fmt.Sprintf("Error reading logs of task %s: %v", tID, err))
+		return
+	}
+
+	w.Header().Set("content-type", "text/plain; charset=utf-8")
+	w.WriteHeader(200)
+	out := &flushWriter{w: w}
+	if err := task.CopyLogs(r.Context(), logs, out, out); err != nil {
+		log.Printf("Error streaming logs of task %s: %v\n", tID, err)
+	}
+}
+
+// ExecTaskHandler runs a command in the task container. The request carries
+// a task.ExecRequest body and asks for an upgrade to task.ExecUpgrade; after
+// 101 Switching Protocols both sides exchange exec frames over the connection.
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, 400, "TaskID passed in the request looks invalid")
+		return
+	}
+	if r.Header.Get("Upgrade") != task.ExecUpgrade {
+		writeError(w, 400, fmt.Sprintf("Exec requires an upgrade to %s", task.ExecUpgrade))
+		return
+	}
+	req := task.ExecRequest{}
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&req); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	if len(req.Cmd) == 0 {
+		writeError(w, 400, "No command to execute")
+		return
+	}
+	t, ok := a.Worker.Db[tID]
+	if !ok {
+		writeError(w, 404, fmt.Sprintf("Task %s not found", tID))
+		return
+	}
+	if t.State != task.Running {
+		writeError(w, 409, fmt.Sprintf("Task %s is %s, not running", tID, t.State))
+		return
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, 500, fmt.Sprintf("Error while taking over the connection: %v", err))
+		return
+	}
+	defer conn.Close()
+	_, _ = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", task.ExecUpgrade)
+	_ = rw.Flush()
+
+	stream := struct {
+		io.Reader
+		io.Writer
+	}{rw.Reader, conn}
+	err = a.Worker.ExecTask(context.Background(), *t, req, stream)
+	if err != nil {
+		log.Printf("Error executing %v in task %s: %v\n", req.Cmd, tID, err)
+		data, _ := json.Marshal(task.ExecResult{ExitCode: -1, Error: err.Error()})
+		_ = task.WriteExecFrame(conn, task.ExecExit, data)
+	}
+}
+
+// flushes after every write so followed logs reach the client right away
+type flushWriter struct {
+	w io.Writer
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if f, ok := fw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	log.Println(msg)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ErrResponse{Message: msg})
+}
+
+func (a *Api) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := a.Worker.Stats
+	if stats == nil {
+		// nothing collected yet
+		stats = GetStats()
+		stats.TaskCount = a.Worker.TaskCount
+		stats.Images = a.Worker.cachedImages()
+	}
 	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(200)
 	_ = json.NewEncoder(w).Encode(stats)
@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func (a *Api) GetImagesHandler(w http.ResponseWriter, r *http.Request) {
+	images, err := a.Worker.GetImages()
+	if err != nil {
+		writeError(w, 500, fmt.Sprintf("Error listing images: %v", err))
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(images)
+}
+
+func (a *Api) PullImageHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := PullRequest{}
+	if err := d.Decode(&req); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	if req.Image == "" {
+		writeError(w, 400, "image is required")
+		return
+	}
+	img, err := a.Worker.PullImage(req)
+	if err != nil {
+		writeError(w, 502, fmt.Sprintf("Error pulling image: %v", err))
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(img)
+}
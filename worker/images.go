@@ -0,0 +1,201 @@
+package worker
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"kjarmicki.github.com/cube/task"
+)
+
+// PullRequest asks a worker to have an image ready before any task needs it
+type PullRequest struct {
+	Image        string
+	RegistryAuth string `json:",omitempty"` // encoded credentials, as in task events
+}
+
+// PullImage fetches an image ahead of time, so that tasks using it start without waiting
+func (w *Worker) PullImage(req PullRequest) (task.Image, error) {
+	d := task.NewDocker(task.Config{Image: req.Image, PullPolicy: task.PullAlways, RegistryAuth: req.RegistryAuth})
+	if err := d.Pull(); err != nil {
+		return task.Image{}, err
+	}
+	log.Printf("[Worker] Pulled image %s\n", req.Image)
+	w.imagesMu.Lock()
+	w.touchImage(task.NormalizeImage(req.Image))
+	if w.prePulled == nil {
+		w.prePulled = make(map[string]bool)
+	}
+	w.prePulled[task.NormalizeImage(req.Image)] = true
+	w.imagesMu.Unlock()
+	images, err := d.Images()
+	if err != nil {
+		return task.Image{}, err
+	}
+	name := task.NormalizeImage(req.Image)
+	for _, img := range images {
+		for _, n := range img.Names {
+			if n == name {
+				return img, nil
+			}
+		}
+	}
+	// pulled by digest, docker lists it under the repository digest only
+	return task.Image{Names: []string{name}}, nil
+}
+
+func (w *Worker) GetImages() ([]task.Image, error) {
+	return task.NewDocker(task.Config{}).Images()
+}
+
+// names of the images cached on the worker, reported with the stats
+func (w *Worker) cachedImages() []string {
+	images, err := w.GetImages()
+	if err != nil {
+		log.Printf("[Worker] Error listing images: %v\n", err)
+		return nil
+	}
+	var names []string
+	for _, img := range images {
+		names = append(names, img.Names...)
+	}
+	return names
+}
+
+func (w *Worker) CollectImageGarbage() {
+	for {
+		if w.ImageGCThreshold > 0 {
+			w.collectImageGarbage()
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+// collectImageGarbage removes images no container uses, least recently pulled
+// or used first, for as long as disk usage stays above the threshold. Images
+// pulled ahead of time are kept until a task has used them, and so are those of
+// queued tasks. Images not pulled or used since the worker started go first.
+func (w *Worker) collectImageGarbage() {
+	d := task.NewDocker(task.Config{})
+	root, err := d.DataRoot()
+	if err != nil {
+		log.Printf("[Worker] Error finding docker's data root: %v\n", err)
+		return
+	}
+	if w.diskUsage(root) <= w.ImageGCThreshold {
+		return
+	}
+	images, err := d.Images()
+	if err != nil {
+		log.Printf("[Worker] Error listing images for garbage collection: %v\n", err)
+		return
+	}
+	candidates, lastUsed := w.unusedImages(images)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return lastUsed[candidates[i].ID].Before(lastUsed[candidates[j].ID])
+	})
+	for _, img := range candidates {
+		if err := d.RemoveImage(img.ID); err != nil {
+			log.Printf("[Worker] Error removing image %s: %v\n", img.ID, err)
+			continue
+		}
+		log.Printf("[Worker] Removed unused image %s %v\n", img.ID, img.Names)
+		w.forgetImage(img)
+		if w.diskUsage(root) <= w.ImageGCThreshold {
+			return
+		}
+	}
+	log.Printf("[Worker] Disk usage still above %d%% with no unused images left\n", w.ImageGCThreshold)
+}
+
+// unusedImages picks the images garbage collection may remove, along with when
+// each was last pulled or used
+func (w *Worker) unusedImages(images []task.Image) ([]task.Image, map[string]time.Time) {
+	w.imagesMu.Lock()
+	defer w.imagesMu.Unlock()
+	var candidates []task.Image
+	lastUsed := make(map[string]time.Time)
+	for _, img := range images {
+		if img.InUse {
+			continue
+		}
+		keep := false
+		for _, name := range img.Names {
+			if w.prePulled[name] || w.queuedImages[name] > 0 {
+				keep = true
+			}
+			if used := w.imageUses[name]; used.After(lastUsed[img.ID]) {
+				lastUsed[img.ID] = used
+			}
+		}
+		if !keep {
+			candidates = append(candidates, img)
+		}
+	}
+	return candidates, lastUsed
+}
+
+func (w *Worker) forgetImage(img task.Image) {
+	w.imagesMu.Lock()
+	defer w.imagesMu.Unlock()
+	for _, name := range img.Names {
+		delete(w.imageUses, name)
+	}
+}
+
+// useImages records that a task is about to use its images
+func (w *Worker) useImages(t task.Task) {
+	w.imagesMu.Lock()
+	defer w.imagesMu.Unlock()
+	for _, name := range taskImages(t) {
+		w.touchImage(name)
+		delete(w.prePulled, name)
+	}
+}
+
+// queueImages counts the images of a task added to the queue, or taken off it
+func (w *Worker) queueImages(t task.Task, delta int) {
+	w.imagesMu.Lock()
+	defer w.imagesMu.Unlock()
+	if w.queuedImages == nil {
+		w.queuedImages = make(map[string]int)
+	}
+	for _, name := range taskImages(t) {
+		w.queuedImages[name] += delta
+		if w.queuedImages[name] <= 0 {
+			delete(w.queuedImages, name)
+		}
+	}
+}
+
+// touchImage expects imagesMu to be held
+func (w *Worker) touchImage(name string) {
+	if w.imageUses == nil {
+		w.imageUses = make(map[string]time.Time)
+	}
+	w.imageUses[name] = time.Now()
+}
+
+// the images of a task and of its init containers, normalized
+func taskImages(t task.Task) []string {
+	var names []string
+	if t.Image != "" {
+		names = append(names, task.NormalizeImage(t.Image))
+	}
+	for _, c := range t.InitContainers {
+		if c.Image != "" {
+			names = append(names, task.NormalizeImage(c.Image))
+		}
+	}
+	return names
+}
+
+// percent of the disk holding docker's data in use
+func (w *Worker) diskUsage(root string) int {
+	var disk DiskStats
+	if err := readDiskStats(&disk, root); err != nil || disk.All == 0 {
+		log.Printf("[Worker] Error reading disk stats of %s: %v\n", root, err)
+		return 0
+	}
+	return int(disk.Used * 100 / disk.All)
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/task"
+)
+
+// SetSecrets holds on to the secret values sent along with a task until it's started
+func (w *Worker) SetSecrets(taskID uuid.UUID, values map[string][]byte) {
+	w.secretsMu.Lock()
+	defer w.secretsMu.Unlock()
+	if w.secrets == nil {
+		w.secrets = make(map[uuid.UUID]map[string][]byte)
+	}
+	w.secrets[taskID] = values
+}
+
+// SetRegistryAuth holds on to the credentials for pulling the images of a task,
+// its own and those of its init containers, until it's started
+func (w *Worker) SetRegistryAuth(taskID uuid.UUID, auth string, initAuth map[string]string) {
+	w.secretsMu.Lock()
+	defer w.secretsMu.Unlock()
+	if w.registryAuth == nil {
+		w.registryAuth = make(map[uuid.UUID]string)
+		w.initRegistryAuth = make(map[uuid.UUID]map[string]string)
+	}
+	w.registryAuth[taskID] = auth
+	w.initRegistryAuth[taskID] = initAuth
+}
+
+func (w *Worker) takeRegistryAuth(taskID uuid.UUID) (string, map[string]string) {
+	w.secretsMu.Lock()
+	defer w.secretsMu.Unlock()
+	auth, initAuth := w.registryAuth[taskID], w.initRegistryAuth[taskID]
+	delete(w.registryAuth, taskID)
+	delete(w.initRegistryAuth, taskID)
+	return auth, initAuth
+}
+
+// values are forgotten once taken, only the files given to the container remain
+func (w *Worker) takeSecrets(taskID uuid.UUID) map[string][]byte {
+	w.secretsMu.Lock()
+	defer w.secretsMu.Unlock()
+	values := w.secrets[taskID]
+	delete(w.secrets, taskID)
+	return values
+}
+
+func (w *Worker) secretsDir(taskID uuid.UUID) string {
+	return filepath.Join(w.SecretsDir, taskID.String())
+}
+
+// materializeSecrets turns the secrets of a task into env vars and read-only
+// files, written to the worker's secrets dir which is meant to be on tmpfs
+func (w *Worker) materializeSecrets(t task.Task) ([]string, []task.Mount, error) {
+	if len(t.Secrets) == 0 {
+		return nil, nil, nil
+	}
+	values := w.takeSecrets(t.ID)
+	var env []string
+	var mounts []task.Mount
+	for i, r := range t.Secrets {
+		value, ok := values[r.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("secret %s wasn't sent along with the task", r.Name)
+		}
+		if r.Env != "" {
+			env = append(env, fmt.Sprintf("%s=%s", r.Env, value))
+			continue
+		}
+		if w.SecretsDir == "" {
+			return nil, nil, errors.New("worker has no secrets dir to keep secret files in")
+		}
+		dir := w.secretsDir(t.ID)
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, nil, err
+		}
+		// the index keeps files apart when one secret is mounted at more than one path
+		p := filepath.Join(dir, fmt.Sprintf("%d-%s", i, r.Name))
+		if err := os.WriteFile(p, value, 0o444); err != nil {
+			return nil, nil, err
+		}
+		mounts = append(mounts, task.Mount{Type: task.MountBind, Source: p, Target: r.File, ReadOnly: true})
+	}
+	return env, mounts, nil
+}
+
+func (w *Worker) removeSecrets(taskID uuid.UUID) {
+	w.takeSecrets(taskID)
+	w.takeRegistryAuth(taskID)
+	if w.SecretsDir == "" {
+		return
+	}
+	_ = os.RemoveAll(w.secretsDir(taskID))
+}
@@ -0,0 +1,158 @@
+package worker
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type MemStats struct {
+	MemTotal     int `json:"mem_total"`
+	MemFree      int `json:"mem_free"`
+	MemAvailable int `json:"mem_available"`
+}
+
+type DiskStats struct {
+	All        int64 `json:"all"`
+	Used       int64 `json:"used"`
+	Free       int64 `json:"free"`
+	FreeInodes int   `json:"freeInodes"`
+}
+
+type CpuStats struct {
+	ID        string `json:"id"`
+	User      int    `json:"user"`
+	Nice      int    `json:"nice"`
+	System    int    `json:"system"`
+	Idle      int    `json:"idle"`
+	Iowait    int    `json:"iowait"`
+	Irq       int    `json:"irq"`
+	Softirq   int    `json:"softirq"`
+	Steal     int    `json:"steal"`
+	Guest     int    `json:"guest"`
+	GuestNice int    `json:"guest_nice"`
+}
+
+type LoadStats struct {
+	Last1Min       float64 `json:"last1min"`
+	Last5Min       float64 `json:"last5min"`
+	Last15Min      float64 `json:"last15min"`
+	ProcessRunning int     `json:"process_running"`
+	ProcessTotal   int     `json:"process_total"`
+	LastPID        int     `json:"last_pid"`
+}
+
+type Stats struct {
+	MemStats  MemStats  `json:"MemStats"`
+	DiskStats DiskStats `json:"DiskStats"`
+	CpuStats  CpuStats  `json:"CpuStats"`
+	LoadStats LoadStats `json:"LoadStats"`
+	Cores     int       `json:"Cores"`
+	TaskCount int       `json:"TaskCount"`
+	Images    []string  `json:"Images"` // normalized names of the cached images
+}
+
+// GetStats reads the current state of the host. Whatever can't be read is left empty.
+func GetStats() *Stats {
+	s := &Stats{Cores: runtime.NumCPU()}
+	if err := readMemStats(&s.MemStats); err != nil {
+		log.Printf("[Worker] Error reading memory stats: %v\n", err)
+	}
+	if err := readDiskStats(&s.DiskStats, "/"); err != nil {
+		log.Printf("[Worker] Error reading disk stats: %v\n", err)
+	}
+	if err := readCpuStats(&s.CpuStats); err != nil {
+		log.Printf("[Worker] Error reading cpu stats: %v\n", err)
+	}
+	if err := readLoadStats(&s.LoadStats); err != nil {
+		log.Printf("[Worker] Error reading load stats: %v\n", err)
+	}
+	return s
+}
+
+// values are in kB, as reported by the kernel
+func readMemStats(m *MemStats) error {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fields := map[string]*int{
+		"MemTotal:":     &m.MemTotal,
+		"MemFree:":      &m.MemFree,
+		"MemAvailable:": &m.MemAvailable,
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) < 2 {
+			continue
+		}
+		if field, ok := fields[parts[0]]; ok {
+			*field, _ = strconv.Atoi(parts[1])
+		}
+	}
+	return scanner.Err()
+}
+
+func readDiskStats(d *DiskStats, path string) error {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return err
+	}
+	d.All = int64(fs.Blocks * uint64(fs.Bsize))
+	d.Free = int64(fs.Bavail * uint64(fs.Bsize))
+	d.Used = int64((fs.Blocks - fs.Bfree) * uint64(fs.Bsize))
+	d.FreeInodes = int(fs.Ffree)
+	return nil
+}
+
+func readCpuStats(c *CpuStats) error {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) < 11 || parts[0] != "cpu" {
+			continue
+		}
+		c.ID = parts[0]
+		values := []*int{&c.User, &c.Nice, &c.System, &c.Idle, &c.Iowait, &c.Irq, &c.Softirq, &c.Steal, &c.Guest, &c.GuestNice}
+		for i, v := range values {
+			*v, _ = strconv.Atoi(parts[i+1])
+		}
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("no cpu line in /proc/stat")
+}
+
+func readLoadStats(l *LoadStats) error {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(string(data))
+	if len(parts) < 5 {
+		return fmt.Errorf("unexpected /proc/loadavg format: %q", data)
+	}
+	l.Last1Min, _ = strconv.ParseFloat(parts[0], 64)
+	l.Last5Min, _ = strconv.ParseFloat(parts[1], 64)
+	l.Last15Min, _ = strconv.ParseFloat(parts[2], 64)
+	if running, total, ok := strings.Cut(parts[3], "/"); ok {
+		l.ProcessRunning, _ = strconv.Atoi(running)
+		l.ProcessTotal, _ = strconv.Atoi(total)
+	}
+	l.LastPID, _ = strconv.Atoi(parts[4])
+	return nil
+}
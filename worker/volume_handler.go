@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"kjarmicki.github.com/cube/volume"
+)
+
+func (a *Api) GetVolumesHandler(w http.ResponseWriter, r *http.Request) {
+	volumes, err := a.Worker.GetVolumes()
+	if err != nil {
+		writeError(w, 500, fmt.Sprintf("Error listing volumes: %v", err))
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	_ = json.NewEncoder(w).Encode(volumes)
+}
+
+func (a *Api) CreateVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := volume.Volume{}
+	if err := d.Decode(&req); err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	if err := volume.ValidateName(req.Name); err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	v, err := a.Worker.CreateVolume(req.Name)
+	if err != nil {
+		writeError(w, 500, fmt.Sprintf("Error creating volume: %v", err))
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(201)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (a *Api) DeleteVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := volume.ValidateName(name); err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	err := a.Worker.DeleteVolume(name)
+	switch {
+	case errors.Is(err, ErrVolumeNotFound):
+		writeError(w, 404, err.Error())
+	case err != nil:
+		writeError(w, 409, err.Error())
+	default:
+		w.WriteHeader(204)
+	}
+}
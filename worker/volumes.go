@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"kjarmicki.github.com/cube/task"
+	"kjarmicki.github.com/cube/volume"
+)
+
+var ErrVolumeNotFound = errors.New("volume not found")
+
+func (w *Worker) volumesDir() string {
+	return filepath.Join(w.DataDir, "volumes")
+}
+
+func (w *Worker) volumePath(name string) string {
+	return filepath.Join(w.volumesDir(), name)
+}
+
+func (w *Worker) GetVolumes() ([]volume.Volume, error) {
+	entries, err := os.ReadDir(w.volumesDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return []volume.Volume{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	volumes := make([]volume.Volume, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		v, err := w.GetVolume(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, v)
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+	return volumes, nil
+}
+
+func (w *Worker) GetVolume(name string) (volume.Volume, error) {
+	if err := volume.ValidateName(name); err != nil {
+		return volume.Volume{}, err
+	}
+	info, err := os.Stat(w.volumePath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return volume.Volume{}, ErrVolumeNotFound
+	}
+	if err != nil {
+		return volume.Volume{}, err
+	}
+	return volume.Volume{
+		Name:      name,
+		Node:      w.Name,
+		Path:      w.volumePath(name),
+		CreatedAt: info.ModTime().UTC(),
+	}, nil
+}
+
+// CreateVolume makes the volume directory, keeping the existing one if there's any
+func (w *Worker) CreateVolume(name string) (volume.Volume, error) {
+	if err := volume.ValidateName(name); err != nil {
+		return volume.Volume{}, err
+	}
+	if err := os.MkdirAll(w.volumePath(name), 0o755); err != nil {
+		return volume.Volume{}, err
+	}
+	log.Printf("[Worker] Created volume %s\n", name)
+	return w.GetVolume(name)
+}
+
+// DeleteVolume removes the volume along with its data, unless a task still uses it
+func (w *Worker) DeleteVolume(name string) error {
+	if _, err := w.GetVolume(name); err != nil {
+		return err
+	}
+	for _, t := range w.Db {
+		if t.State != task.Running && t.State != task.Scheduled {
+			continue
+		}
+		for _, claimed := range t.LocalVolumes() {
+			if claimed == name {
+				return fmt.Errorf("volume %s is in use by task %s", name, t.ID)
+			}
+		}
+	}
+	if err := os.RemoveAll(w.volumePath(name)); err != nil {
+		return err
+	}
+	log.Printf("[Worker] Deleted volume %s\n", name)
+	return nil
+}
+
+// resolveMounts turns claims of local volumes into bind mounts of their directories
+func (w *Worker) resolveMounts(mounts []task.Mount) ([]task.Mount, error) {
+	resolved := make([]task.Mount, 0, len(mounts))
+	for _, m := range mounts {
+		if m.Type == task.MountLocal {
+			v, err := w.CreateVolume(m.Source)
+			if err != nil {
+				return nil, fmt.Errorf("volume %s: %w", m.Source, err)
+			}
+			m.Type = task.MountBind
+			m.Source = v.Path
+		}
+		resolved = append(resolved, m)
+	}
+	return resolved, nil
+}
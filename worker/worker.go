@@ -1,11 +1,18 @@
 package worker
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
 	"kjarmicki.github.com/cube/task"
@@ -16,10 +23,47 @@ type Worker struct {
 	Queue     queue.Queue              // tasks accepted from the manager, waiting to be run
 	Db        map[uuid.UUID]*task.Task // tasks that are currently running
 	TaskCount int
+	Stats     *Stats
+	// host paths, with everything below them, that tasks may bind mount
+	AllowedHostPaths []string
+	DataDir          string // persistent volumes are kept under it
+	SecretsDir       string // secret files are written there, it should be on tmpfs
+	// disk usage percent above which unused images are removed, never when 0
+	ImageGCThreshold int
+	secrets          map[uuid.UUID]map[string][]byte
+	registryAuth     map[uuid.UUID]string
+	initRegistryAuth map[uuid.UUID]map[string]string
+	secretsMu        sync.Mutex
+	configs          map[uuid.UUID]map[string][]byte
+	configsMu        sync.Mutex
+	initCancels      map[uuid.UUID]context.CancelFunc // stop the init containers of tasks being started
+	initMu           sync.Mutex
+	imageUses        map[string]time.Time // when images were last pulled or used by a task, by name
+	prePulled        map[string]bool      // pulled ahead of time and not used by any task since
+	queuedImages     map[string]int       // images of tasks waiting in the queue
+	imagesMu         sync.Mutex
 }
 
 func (w *Worker) CollectStats() {
-	log.Println("[Worker] I will collect stats")
+	for {
+		log.Println("[Worker] Collecting stats")
+		stats := GetStats()
+		w.TaskCount = w.countRunningTasks()
+		stats.TaskCount = w.TaskCount
+		stats.Images = w.cachedImages()
+		w.Stats = stats
+		time.Sleep(15 * time.Second)
+	}
+}
+
+func (w *Worker) countRunningTasks() int {
+	count := 0
+	for _, t := range w.Db {
+		if t.State == task.Running {
+			count++
+		}
+	}
+	return count
 }
 
 func (w *Worker) runTask() task.DockerResult {
@@ -29,6 +73,8 @@ func (w *Worker) runTask() task.DockerResult {
 		return task.DockerResult{Error: nil}
 	}
 	taskQueued := t.(task.Task)
+	// its images are kept until it has started
+	defer w.queueImages(taskQueued, -1)
 
 	taskPersisted := w.Db[taskQueued.ID] // if task isn't enqueued, enqueue it
 	if taskPersisted == nil {
@@ -53,7 +99,41 @@ func (w *Worker) runTask() task.DockerResult {
 	return result
 }
 
+// CheckMounts refuses bind mounts of host paths outside of the allowlist
+func (w *Worker) CheckMounts(t task.Task) error {
+	for _, m := range t.Mounts {
+		if m.Type != task.MountBind {
+			continue
+		}
+		if !w.isHostPathAllowed(m.Source) {
+			return fmt.Errorf("bind mounting %s is not allowed on this worker", m.Source)
+		}
+	}
+	return nil
+}
+
+// isHostPathAllowed compares paths with their symlinks resolved, so that a link
+// inside an allowed directory can't lead outside of it. Paths that don't exist
+// are refused, docker couldn't mount them anyway.
+func (w *Worker) isHostPathAllowed(p string) bool {
+	p, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range w.AllowedHostPaths {
+		if resolved, err := filepath.EvalSymlinks(allowed); err == nil {
+			allowed = resolved
+		}
+		rel, err := filepath.Rel(filepath.Clean(allowed), p)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return true
+		}
+	}
+	return false
+}
+
 func (w *Worker) AddTask(t task.Task) {
+	w.queueImages(t, 1)
 	w.Queue.Enqueue(t)
 }
 
@@ -67,27 +147,225 @@ func (w *Worker) GetTasks() []*task.Task {
 
 func (w *Worker) StartTask(t task.Task) task.DockerResult {
 	t.StartTime = time.Now().UTC()
-	config := task.NewConfig(&t)
+	w.useImages(t)
+	config, err := w.prepareConfig(t)
+	if err != nil {
+		log.Printf("[Worker] Error preparing task %s: %v\n", t.ID, err)
+		return w.failTask(t, task.ReasonStartFailed, err)
+	}
+	if err := w.runInitContainers(t, config); errors.Is(err, context.Canceled) {
+		// asked to stop before it got to start
+		log.Printf("[Worker] Task %s stopped while initializing\n", t.ID)
+		w.removeTaskFiles(t.ID)
+		t.State = task.Completed
+		t.FinishTime = time.Now().UTC()
+		w.Db[t.ID] = &t
+		return task.DockerResult{Action: "stop", Result: "success"}
+	} else if err != nil {
+		log.Printf("[Worker] Error initializing task %s: %v\n", t.ID, err)
+		return w.failTask(t, task.ReasonInitContainerFailed, err)
+	}
 	d := task.NewDocker(config)
 	result := d.Run()
 	if result.Error != nil {
 		log.Printf("[Worker] Error running task %s: %v\n", t.ID, result.Error)
-		t.State = task.Failed
-		w.Db[t.ID] = &t
+		reason := task.ReasonStartFailed
+		var pullErr *task.PullError
+		if errors.As(result.Error, &pullErr) {
+			reason = pullErr.Reason
+		}
+		w.failTask(t, reason, result.Error)
 		return result
 	}
+	if err := d.PostStart(result.ContainerId); err != nil {
+		log.Printf("[Worker] Post-start hook of task %s failed: %v\n", t.ID, err)
+		d.Stop(result.ContainerId)
+		t.ContainerID = result.ContainerId
+		return w.failTask(t, task.ReasonPostStartHookFailed, err)
+	}
 	t.ContainerID = result.ContainerId
 	t.State = task.Running
 	w.Db[t.ID] = &t
 	return result
 }
 
+// runInitContainers runs the init containers of a task one after another,
+// stopping at the first one that fails or runs out of time. Stopping the task
+// cancels them.
+func (w *Worker) runInitContainers(t task.Task, config task.Config) error {
+	if len(t.InitContainers) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.initMu.Lock()
+	if w.initCancels == nil {
+		w.initCancels = make(map[uuid.UUID]context.CancelFunc)
+	}
+	w.initCancels[t.ID] = cancel
+	w.initMu.Unlock()
+	defer func() {
+		w.initMu.Lock()
+		delete(w.initCancels, t.ID)
+		w.initMu.Unlock()
+		cancel()
+	}()
+
+	for _, c := range t.InitContainers {
+		if persisted, ok := w.Db[t.ID]; ok && persisted.State == task.Scheduled {
+			persisted.Substatus = task.SubstatusInitializing
+			persisted.PullProgress = nil
+		}
+		log.Printf("[Worker] Running init container %s of task %s\n", c.Name, t.ID)
+		d := task.NewDocker(config.InitConfig(c))
+		initCtx, initCancel := context.WithTimeout(ctx, c.Timeout())
+		err := d.RunToCompletion(initCtx)
+		timedOut := errors.Is(initCtx.Err(), context.DeadlineExceeded)
+		initCancel()
+		if ctx.Err() != nil {
+			return fmt.Errorf("init container %s: %w", c.Name, ctx.Err())
+		}
+		if err != nil && timedOut {
+			return fmt.Errorf("init container %s didn't finish within %s", c.Name, c.Timeout())
+		}
+		if err != nil {
+			return fmt.Errorf("init container %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// CancelInit stops the init containers of a task that's being started, if it's
+// still at it, so that stopping the task doesn't wait for them to finish
+func (w *Worker) CancelInit(taskID uuid.UUID) {
+	w.initMu.Lock()
+	defer w.initMu.Unlock()
+	if cancel, ok := w.initCancels[taskID]; ok {
+		log.Printf("[Worker] Cancelling init containers of task %s\n", taskID)
+		cancel()
+	}
+}
+
+// prepareConfig resolves the mounts of a task and hands it its secrets, configs
+// and registry credentials
+func (w *Worker) prepareConfig(t task.Task) (task.Config, error) {
+	config := task.NewConfig(&t)
+	if t.InfraTaskID != uuid.Nil {
+		infra, ok := w.Db[t.InfraTaskID]
+		if !ok || infra.State != task.Running {
+			return config, fmt.Errorf("infra task %s of the group isn't running here", t.InfraTaskID)
+		}
+		config.NetworkMode = "container:" + infra.ContainerID
+	}
+	mounts, err := w.resolveMounts(t.Mounts)
+	if err != nil {
+		return config, err
+	}
+	env, secretMounts, err := w.materializeSecrets(t)
+	if err != nil {
+		return config, err
+	}
+	configMounts, err := w.materializeConfigs(t)
+	if err != nil {
+		return config, err
+	}
+	config.Env = append(config.Env, env...)
+	config.Mounts = append(append(mounts, secretMounts...), configMounts...)
+	config.RegistryAuth, config.InitRegistryAuth = w.takeRegistryAuth(t.ID)
+	config.PullProgress = func(p task.PullProgress) {
+		w.reportPullProgress(t.ID, p)
+	}
+	return config, nil
+}
+
+// reportPullProgress shows the progress on the task that's still scheduled,
+// it gets replaced once the task starts or fails
+func (w *Worker) reportPullProgress(id uuid.UUID, p task.PullProgress) {
+	if t, ok := w.Db[id]; ok && t.State == task.Scheduled {
+		t.Substatus = task.SubstatusPulling
+		t.PullProgress = &p
+	}
+}
+
+func (w *Worker) failTask(t task.Task, reason string, err error) task.DockerResult {
+	w.removeTaskFiles(t.ID)
+	t.State = task.Failed
+	t.FailureReason = reason
+	t.FailureMessage = err.Error()
+	w.Db[t.ID] = &t
+	return task.DockerResult{Error: err}
+}
+
+// removeTaskFiles drops the secret and config files written for a task
+func (w *Worker) removeTaskFiles(taskID uuid.UUID) {
+	w.removeSecrets(taskID)
+	w.removeConfigs(taskID)
+}
+
 func (w *Worker) InspectTask(t task.Task) task.DockerInspectResponse {
 	config := task.NewConfig(&t)
 	d := task.NewDocker(config)
 	return d.Inspect(t.ContainerID)
 }
 
+func (w *Worker) TaskLogs(ctx context.Context, t task.Task, opts task.LogsOptions) (io.ReadCloser, error) {
+	config := task.NewConfig(&t)
+	d := task.NewDocker(config)
+	return d.OpenLogs(ctx, t.ContainerID, opts)
+}
+
+// ExecTask runs a command inside the task container, wiring its output into
+// frames on conn and feeding stdin frames read from conn back into it. Errors
+// are returned only when the command couldn't be started, afterwards the
+// outcome is sent in the exit frame.
+func (w *Worker) ExecTask(ctx context.Context, t task.Task, req task.ExecRequest, conn io.ReadWriter) error {
+	config := task.NewConfig(&t)
+	d := task.NewDocker(config)
+	session, err := d.Exec(ctx, t.ContainerID, req)
+	if err != nil {
+		return err
+	}
+	defer session.Conn.Close()
+
+	go func() {
+		for {
+			kind, payload, err := task.ReadExecFrame(conn)
+			if err != nil {
+				return
+			}
+			switch kind {
+			case task.ExecStdin:
+				if _, err := session.Conn.Conn.Write(payload); err != nil {
+					return
+				}
+			case task.ExecStdinClose:
+				_ = session.Conn.CloseWrite()
+			}
+		}
+	}()
+
+	stdout := task.ExecFrameWriter{W: conn, Kind: task.ExecStdout}
+	stderr := task.ExecFrameWriter{W: conn, Kind: task.ExecStderr}
+	if session.Tty {
+		_, err = io.Copy(stdout, session.Conn.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, session.Conn.Reader)
+	}
+	if err != nil {
+		log.Printf("[Worker] Error while streaming exec %s output: %v\n", session.ID, err)
+	}
+
+	result := task.ExecResult{}
+	result.ExitCode, err = d.ExecExitCode(ctx, session.ID)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	data, _ := json.Marshal(result)
+	if err := task.WriteExecFrame(conn, task.ExecExit, data); err != nil {
+		log.Printf("[Worker] Error while sending exit status of exec %s: %v\n", session.ID, err)
+	}
+	return nil
+}
+
 func (w *Worker) UpdateTasks() {
 	for {
 		log.Println("[Worker] Checking status of tasks")
@@ -109,11 +387,19 @@ func (w *Worker) updateTasks() {
 			if resp.Container == nil {
 				log.Printf("[Worker] No container for running task %s\n", t.ID)
 				w.Db[t.ID].State = task.Failed
+				continue
 			}
 
 			if resp.Container.State.Status == "exited" {
-				log.Printf("[Worker] Container for task %s has exited\n", t.ID)
-				w.Db[t.ID].State = task.Failed
+				log.Printf("[Worker] Container for task %s has exited with code %d\n", t.ID, resp.Container.State.ExitCode)
+				w.Db[t.ID].ExitCode = resp.Container.State.ExitCode
+				w.Db[t.ID].FinishTime = time.Now().UTC()
+				// a batch task exiting cleanly is done, anything else going away is a failure
+				if t.RunsToCompletion() && resp.Container.State.ExitCode == 0 {
+					w.Db[t.ID].State = task.Completed
+				} else {
+					w.Db[t.ID].State = task.Failed
+				}
 			}
 			w.Db[t.ID].HostPorts = resp.Container.NetworkSettings.NetworkSettingsBase.Ports
 		}
@@ -128,10 +414,12 @@ func (w *Worker) StopTask(t task.Task) task.DockerResult {
 	if result.Error != nil {
 		log.Printf("[Worker] Error stopping container %s: %v\n", t.ContainerID, result.Error)
 	}
+	w.removeTaskFiles(t.ID)
+	t.ExitCode = result.ExitCode
 	t.FinishTime = time.Now().UTC()
 	t.State = task.Completed
 	w.Db[t.ID] = &t
-	log.Printf("[Worker] Stopped and removed container %s for task %d\n", t.ContainerID, t.ID)
+	log.Printf("[Worker] Stopped container %s for task %s\n", t.ContainerID, t.ID)
 	return result
 }
 
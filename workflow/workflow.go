@@ -0,0 +1,168 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"kjarmicki.github.com/cube/task"
+)
+
+// what happens to the steps downstream of a failed one
+const (
+	FailDownstream = "fail" // they are marked as failed
+	SkipDownstream = "skip" // they are marked as skipped
+)
+
+// states of a workflow and of its steps
+const (
+	Waiting   = "waiting" // step waits for the steps it depends on
+	Running   = "running"
+	Succeeded = "succeeded"
+	Failed    = "failed"
+	Skipped   = "skipped"
+)
+
+// Workflow is a set of tasks run in the order given by their dependencies
+type Workflow struct {
+	ID        uuid.UUID
+	Name      string
+	Steps     []Step
+	OnFailure string // fail when not set
+	State     string
+	CreatedAt time.Time
+	Finished  time.Time
+}
+
+type Step struct {
+	Name      string
+	Task      task.Task
+	DependsOn []string // names of steps which have to succeed first
+	State     string
+	Reason    string
+}
+
+type Edge struct {
+	From string
+	To   string
+}
+
+type Graph struct {
+	Nodes []Step
+	Edges []Edge
+}
+
+func (w *Workflow) Validate() error {
+	if w.Name == "" {
+		return errors.New("workflow name is required")
+	}
+	if len(w.Steps) == 0 {
+		return errors.New("workflow needs at least one step")
+	}
+	switch w.OnFailure {
+	case "", FailDownstream, SkipDownstream:
+	default:
+		return fmt.Errorf("unknown failure policy %q", w.OnFailure)
+	}
+	names := make(map[string]bool)
+	for _, s := range w.Steps {
+		if s.Name == "" {
+			return errors.New("every step needs a name")
+		}
+		if names[s.Name] {
+			return fmt.Errorf("step %s is declared twice", s.Name)
+		}
+		if s.Task.Image == "" {
+			return fmt.Errorf("task of step %s needs an image", s.Name)
+		}
+		if err := s.Task.Validate(); err != nil {
+			return fmt.Errorf("task of step %s: %w", s.Name, err)
+		}
+		names[s.Name] = true
+	}
+	for _, s := range w.Steps {
+		for _, d := range s.DependsOn {
+			if !names[d] {
+				return fmt.Errorf("step %s depends on unknown step %s", s.Name, d)
+			}
+		}
+	}
+	if _, err := w.Order(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Order sorts steps topologically, failing when dependencies form a cycle
+func (w *Workflow) Order() ([]string, error) {
+	inDegree := make(map[string]int)
+	dependents := make(map[string][]string)
+	for _, s := range w.Steps {
+		inDegree[s.Name] += 0
+		for _, d := range s.DependsOn {
+			inDegree[s.Name]++
+			dependents[d] = append(dependents[d], s.Name)
+		}
+	}
+	var ready, order []string
+	for _, s := range w.Steps {
+		if inDegree[s.Name] == 0 {
+			ready = append(ready, s.Name)
+		}
+	}
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, d := range dependents[name] {
+			inDegree[d]--
+			if inDegree[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+	if len(order) != len(w.Steps) {
+		return nil, errors.New("step dependencies form a cycle")
+	}
+	return order, nil
+}
+
+func (w *Workflow) Step(name string) *Step {
+	for i := range w.Steps {
+		if w.Steps[i].Name == name {
+			return &w.Steps[i]
+		}
+	}
+	return nil
+}
+
+func (w *Workflow) Graph() Graph {
+	g := Graph{Nodes: w.Steps, Edges: []Edge{}}
+	for _, s := range w.Steps {
+		for _, d := range s.DependsOn {
+			g.Edges = append(g.Edges, Edge{From: d, To: s.Name})
+		}
+	}
+	return g
+}
+
+// Prepare gives every step a fresh task owned by the workflow
+func (w *Workflow) Prepare() {
+	if w.OnFailure == "" {
+		w.OnFailure = FailDownstream
+	}
+	w.State = Running
+	for i := range w.Steps {
+		s := &w.Steps[i]
+		s.Task.ID = uuid.New()
+		s.Task.Name = fmt.Sprintf("%s-%s-%s", w.Name, s.Name, s.Task.ID.String()[:8])
+		s.Task.State = task.Pending
+		s.Task.WorkflowID = w.ID
+		s.State = Waiting
+	}
+}
+
+func IsFinished(state string) bool {
+	return state == Succeeded || state == Failed || state == Skipped
+}